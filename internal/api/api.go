@@ -3,26 +3,58 @@ package api
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"runtime"
 	"slices"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
+	"unicode"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/noueii/go-http-server/internal/auth"
 	"github.com/noueii/go-http-server/internal/database"
+	"github.com/noueii/go-http-server/internal/fanout"
+	"github.com/noueii/go-http-server/internal/mailer"
+	"github.com/noueii/go-http-server/internal/oidc"
+	"github.com/noueii/go-http-server/internal/storage"
 )
 
 type config struct {
 	fileserverHits atomic.Int32
 	Db             *database.Queries
+	DbConn         *sql.DB
 	Platform       string
 	Secret         string
+	Tokens         auth.Tokens
+	Passwords      *auth.PasswordHasher
 	PolkaKey       string
+	Fanout         *fanout.Worker
+	Media          storage.Store
+	MediaMaxBytes  int64
+	Mailer         mailer.Mailer
+	BaseURL        string
+	// Providers are the OpenID Connect providers users can sign in with,
+	// by name.
+	Providers map[string]*oidc.Provider
+	// Sealer encrypts the secrets stored in the database, and is nil when
+	// ENCRYPTION_KEY is not set.
+	Sealer *auth.Sealer
+	// RefreshTokenTTL is how long a refresh token lasts after it is issued.
+	RefreshTokenTTL time.Duration
+	// RequireVerifiedEmail stops users chirping until they verify their email.
+	RequireVerifiedEmail bool
+
+	// resetSlots holds a token for every password reset running in the
+	// background.
+	resetSlots chan struct{}
 }
 
 type API struct {
@@ -44,11 +76,102 @@ func Load() (*API, error) {
 	polkaKey := os.Getenv("POLKA_KEY")
 	dbQueries := database.New(dbConn)
 
+	fanoutThreshold := 10000
+
+	if os.Getenv("FANOUT_THRESHOLD") != "" {
+		fanoutThreshold, err = strconv.Atoi(os.Getenv("FANOUT_THRESHOLD"))
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fanoutWorker := fanout.New(dbQueries, int32(fanoutThreshold), 1024)
+	fanoutWorker.Start(4)
+
+	mediaStore, err := loadMediaStore()
+
+	if err != nil {
+		return nil, err
+	}
+
+	mediaMaxBytes := int64(defaultMediaMaxBytes)
+
+	if os.Getenv("MEDIA_MAX_BYTES") != "" {
+		mediaMaxBytes, err = strconv.ParseInt(os.Getenv("MEDIA_MAX_BYTES"), 10, 64)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mail, err := loadMailer()
+
+	if err != nil {
+		return nil, err
+	}
+
+	mailQueue := mailer.NewQueue(mail, mailQueueSize, mailTimeout)
+	mailQueue.Start(mailWorkers)
+
+	sealer, err := loadSealer()
+
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := loadTokens(signingKeyStore{Queries: dbQueries, conn: dbConn}, secret, sealer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	passwords, err := loadPasswordHasher()
+
+	if err != nil {
+		return nil, err
+	}
+
+	refreshTokenTTL, err := durationEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := os.Getenv("BASE_URL")
+
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	providers, err := loadOIDCProviders(baseURL)
+
+	if err != nil {
+		return nil, err
+	}
+
 	cfg := &config{
-		Db:       dbQueries,
-		Platform: platform,
-		Secret:   secret,
-		PolkaKey: polkaKey,
+		Db:            dbQueries,
+		DbConn:        dbConn,
+		Platform:      platform,
+		Secret:        secret,
+		Tokens:        tokens,
+		Passwords:     passwords,
+		PolkaKey:      polkaKey,
+		Fanout:        fanoutWorker,
+		Media:         mediaStore,
+		MediaMaxBytes: mediaMaxBytes,
+		Mailer:        mailQueue,
+		BaseURL:       baseURL,
+		Providers:     providers,
+		Sealer:        sealer,
+
+		RefreshTokenTTL:      refreshTokenTTL,
+		RequireVerifiedEmail: os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
+
+		resetSlots: make(chan struct{}, passwordResetWorkers),
 	}
 
 	fs, err := initFileServer()
@@ -70,6 +193,338 @@ func Load() (*API, error) {
 	}, nil
 }
 
+// Close stops the background workers. Call it once the server has stopped
+// taking requests; queued fan-out jobs and mail are finished first.
+func (a *API) Close() {
+	a.Config.Fanout.Stop()
+
+	// Taking every slot waits out password resets still looking up users,
+	// which may queue mail.
+	for range cap(a.Config.resetSlots) {
+		a.Config.resetSlots <- struct{}{}
+	}
+
+	queue, ok := a.Config.Mailer.(*mailer.Queue)
+
+	if ok {
+		queue.Stop()
+	}
+
+	ring, ok := a.Config.Tokens.(*auth.KeyRing)
+
+	if ok {
+		ring.Stop()
+	}
+}
+
+// loadMediaStore picks the blob store for uploads from MEDIA_STORAGE, either
+// "local" (the default, files under MEDIA_DIR) or "s3".
+func loadMediaStore() (storage.Store, error) {
+	switch os.Getenv("MEDIA_STORAGE") {
+	case "", "local":
+		dir := os.Getenv("MEDIA_DIR")
+
+		if dir == "" {
+			dir = "media"
+		}
+
+		return storage.NewLocal(dir)
+
+	case "s3":
+		return storage.NewS3(
+			os.Getenv("S3_ENDPOINT"),
+			os.Getenv("S3_BUCKET"),
+			os.Getenv("S3_REGION"),
+			os.Getenv("S3_ACCESS_KEY"),
+			os.Getenv("S3_SECRET_KEY"),
+		), nil
+	}
+
+	return nil, fmt.Errorf("Unknown MEDIA_STORAGE %q", os.Getenv("MEDIA_STORAGE"))
+}
+
+// loadMailer picks how outgoing mail is delivered from MAILER: "log" (the
+// default, printed to stdout), "file" (appended to MAIL_FILE) or "smtp".
+func loadMailer() (mailer.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+
+	if from == "" {
+		from = "chirpy@localhost"
+	}
+
+	switch os.Getenv("MAILER") {
+	case "", "log":
+		return &mailer.Log{From: from, W: os.Stdout}, nil
+
+	case "file":
+		path := os.Getenv("MAIL_FILE")
+
+		if path == "" {
+			path = "mail.log"
+		}
+
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &mailer.Log{From: from, W: f}, nil
+
+	case "smtp":
+		return &mailer.SMTP{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			From:     from,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown MAILER %q", os.Getenv("MAILER"))
+}
+
+const (
+	defaultRefreshTokenTTL = 60 * 24 * time.Hour
+	defaultJWTLeeway       = 30 * time.Second
+)
+
+// loadSealer reads ENCRYPTION_KEY, 32 base64 encoded bytes that encrypt
+// signing keys and TOTP secrets in the database. Without it they are stored
+// in the clear.
+func loadSealer() (*auth.Sealer, error) {
+	if os.Getenv("ENCRYPTION_KEY") == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(os.Getenv("ENCRYPTION_KEY"))
+
+	if err != nil {
+		return nil, fmt.Errorf("ENCRYPTION_KEY must be base64: %w", err)
+	}
+
+	return auth.NewSealer(key)
+}
+
+// loadTokens picks how access tokens are signed. JWT_ALGORITHM defaults to
+// HS256 with SECRET; RS256, ES256 and EdDSA use a key ring stored in the
+// database that rotates every JWT_ROTATION_INTERVAL and keeps old keys valid
+// for JWT_ROTATION_OVERLAP. Its private keys are encrypted with the sealer,
+// and stored in the clear without one. JWT_ISSUER, JWT_AUDIENCE,
+// ACCESS_TOKEN_TTL and JWT_LEEWAY shape the tokens themselves.
+func loadTokens(db auth.KeyStore, secret string, sealer *auth.Sealer) (auth.Tokens, error) {
+	tokenConfig := auth.DefaultTokenConfig
+
+	if os.Getenv("JWT_ISSUER") != "" {
+		tokenConfig.Issuer = os.Getenv("JWT_ISSUER")
+	}
+
+	tokenConfig.Audience = os.Getenv("JWT_AUDIENCE")
+
+	ttl, err := durationEnv("ACCESS_TOKEN_TTL", tokenConfig.TTL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl <= 0 {
+		return nil, fmt.Errorf("ACCESS_TOKEN_TTL must be positive")
+	}
+
+	tokenConfig.TTL = ttl
+
+	tokenConfig.Leeway, err = durationEnv("JWT_LEEWAY", defaultJWTLeeway)
+
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm := os.Getenv("JWT_ALGORITHM")
+
+	if algorithm == "" || algorithm == "HS256" {
+		return auth.SharedSecret{Secret: secret, Config: tokenConfig}, nil
+	}
+
+	rotateEvery, err := durationEnv("JWT_ROTATION_INTERVAL", 30*24*time.Hour)
+
+	if err != nil {
+		return nil, err
+	}
+
+	overlap, err := durationEnv("JWT_ROTATION_OVERLAP", 2*time.Hour)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ring, err := auth.NewKeyRing(db, algorithm, tokenConfig, rotateEvery, overlap, sealer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ring.Start(time.Minute)
+	return ring, nil
+}
+
+// signingKeyStore lets the key ring rotate its keys in one transaction.
+type signingKeyStore struct {
+	*database.Queries
+	conn *sql.DB
+}
+
+func (s signingKeyStore) InTx(ctx context.Context, fn func(tx auth.KeyStore) error) error {
+	tx, err := s.conn.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = fn(signingKeyStore{Queries: s.Queries.WithTx(tx), conn: s.conn})
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// userClaims are the role, plan, token version and session claims carried
+// in the user's access token.
+func userClaims(user database.User, sessionId uuid.UUID) auth.UserClaims {
+	return auth.UserClaims{
+		Role:         user.Role,
+		IsChirpyRed:  user.IsChirpyRed.Bool,
+		TokenVersion: user.TokenVersion,
+		SessionID:    sessionId.String(),
+	}
+}
+
+// loadPasswordHasher configures password hashing from PASSWORD_HASH
+// (argon2id or bcrypt), ARGON2_MEMORY (KiB), ARGON2_ITERATIONS,
+// ARGON2_PARALLELISM, BCRYPT_COST and PASSWORD_HASH_WORKERS, the number of
+// hashes computed at once.
+func loadPasswordHasher() (*auth.PasswordHasher, error) {
+	algorithm := os.Getenv("PASSWORD_HASH")
+
+	if algorithm == "" {
+		algorithm = auth.AlgorithmArgon2id
+	}
+
+	params := auth.DefaultArgon2Params
+
+	for _, setting := range []struct {
+		name  string
+		value *uint32
+	}{
+		{"ARGON2_MEMORY", &params.Memory},
+		{"ARGON2_ITERATIONS", &params.Iterations},
+	} {
+		if os.Getenv(setting.name) == "" {
+			continue
+		}
+
+		n, err := strconv.ParseUint(os.Getenv(setting.name), 10, 32)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s: %w", setting.name, err)
+		}
+
+		*setting.value = uint32(n)
+	}
+
+	if os.Getenv("ARGON2_PARALLELISM") != "" {
+		n, err := strconv.ParseUint(os.Getenv("ARGON2_PARALLELISM"), 10, 8)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ARGON2_PARALLELISM: %w", err)
+		}
+
+		params.Parallelism = uint8(n)
+	}
+
+	bcryptCost := auth.DefaultBcryptCost
+
+	if os.Getenv("BCRYPT_COST") != "" {
+		n, err := strconv.Atoi(os.Getenv("BCRYPT_COST"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid BCRYPT_COST: %w", err)
+		}
+
+		bcryptCost = n
+	}
+
+	workers := max(runtime.NumCPU()/2, 1)
+
+	if os.Getenv("PASSWORD_HASH_WORKERS") != "" {
+		n, err := strconv.Atoi(os.Getenv("PASSWORD_HASH_WORKERS"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid PASSWORD_HASH_WORKERS: %w", err)
+		}
+
+		workers = n
+	}
+
+	return auth.NewPasswordHasher(algorithm, params, bcryptCost, workers)
+}
+
+// loadOIDCProviders reads the sign-in providers named in OIDC_PROVIDERS, a
+// comma separated list. Each name needs OIDC_<NAME>_ISSUER and
+// OIDC_<NAME>_CLIENT_ID, and may set OIDC_<NAME>_CLIENT_SECRET and
+// OIDC_<NAME>_SCOPES. The provider redirects back to
+// BASE_URL/api/auth/<name>/callback.
+func loadOIDCProviders(baseURL string) (map[string]*oidc.Provider, error) {
+	providers := map[string]*oidc.Provider{}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if name == "" {
+			continue
+		}
+
+		if !isValidHandle(name) {
+			return nil, fmt.Errorf("Invalid OIDC provider name %q", name)
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+
+		config := oidc.Config{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  baseURL + "/api/auth/" + name + "/callback",
+			Scopes:       strings.Fields(strings.ReplaceAll(os.Getenv(prefix+"SCOPES"), ",", " ")),
+		}
+
+		if config.Issuer == "" || config.ClientID == "" {
+			return nil, fmt.Errorf("%sISSUER and %sCLIENT_ID are required", prefix, prefix)
+		}
+
+		providers[name] = oidc.NewProvider(config)
+	}
+
+	return providers, nil
+}
+
+func durationEnv(name string, fallback time.Duration) (time.Duration, error) {
+	if os.Getenv(name) == "" {
+		return fallback, nil
+	}
+
+	d, err := time.ParseDuration(os.Getenv(name))
+
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s: %w", name, err)
+	}
+
+	return d, nil
+}
+
 func initFileServer() (*http.Handler, error) {
 
 	fileServer := http.FileServer(http.Dir("."))
@@ -82,18 +537,55 @@ func initServeMux(c *config, fs *http.Handler) (*http.ServeMux, error) {
 
 	serveMux.HandleFunc("GET /admin/metrics", c.handlerHits)
 	serveMux.HandleFunc("POST /admin/reset", c.handlerReset)
+	serveMux.HandleFunc("POST /admin/users/{userId}/unlock", c.RequireRole(auth.RoleAdmin, c.handlerUnlockUser))
+	serveMux.HandleFunc("POST /admin/users/{userId}/suspend", c.RequireRole(auth.RoleAdmin, c.handlerSuspendUser))
+	serveMux.HandleFunc("DELETE /admin/users/{userId}/suspend", c.RequireRole(auth.RoleAdmin, c.handlerUnsuspendUser))
 	serveMux.Handle("/api/", http.StripPrefix("/api", *fs))
 	serveMux.Handle("/app/", c.middlewareMetricsInc(http.StripPrefix("/app", *fs)))
-	serveMux.HandleFunc("POST /api/chirps", c.handlerCreateChirp)
-	serveMux.HandleFunc("GET /api/chirps", c.handlerGetAllChirps)
-	serveMux.HandleFunc("GET /api/chirps/{chirpId}", c.handlerGetChirp)
-	serveMux.HandleFunc("DELETE /api/chirps/{chirpId}", c.handlerDeleteChirp)
+	serveMux.HandleFunc("POST /api/chirps", c.RequireAuth(c.handlerCreateChirp))
+	serveMux.HandleFunc("GET /api/chirps", c.OptionalAuth(c.handlerGetAllChirps))
+	serveMux.HandleFunc("GET /api/chirps/search", c.OptionalAuth(c.handlerSearchChirps))
+	serveMux.HandleFunc("GET /api/chirps/{chirpId}", c.OptionalAuth(c.handlerGetChirp))
+	serveMux.HandleFunc("PATCH /api/chirps/{chirpId}", c.RequireAuth(c.handlerUpdateChirp))
+	serveMux.HandleFunc("DELETE /api/chirps/{chirpId}", c.RequireAuth(c.handlerDeleteChirp))
+	serveMux.HandleFunc("GET /api/chirps/{chirpId}/revisions", c.handlerGetChirpRevisions)
+	serveMux.HandleFunc("GET /api/chirps/{chirpId}/replies", c.OptionalAuth(c.handlerGetChirpReplies))
+	serveMux.HandleFunc("GET /api/chirps/{chirpId}/thread", c.OptionalAuth(c.handlerGetChirpThread))
+	serveMux.HandleFunc("POST /api/chirps/{chirpId}/like", c.RequireAuth(c.handlerLikeChirp))
+	serveMux.HandleFunc("DELETE /api/chirps/{chirpId}/like", c.RequireAuth(c.handlerUnlikeChirp))
 	serveMux.HandleFunc("GET /api/healthz", handlerHealth)
+	serveMux.HandleFunc("GET /.well-known/jwks.json", c.handlerJWKS)
 	serveMux.HandleFunc("POST /api/users", c.handlerNewUser)
 	serveMux.HandleFunc("POST /api/login", c.handlerLogin)
+	serveMux.HandleFunc("POST /api/login/mfa", c.handlerLoginMFA)
+	serveMux.HandleFunc("GET /api/auth/{provider}/login", c.handlerOIDCLogin)
+	serveMux.HandleFunc("GET /api/auth/{provider}/callback", c.handlerOIDCCallback)
 	serveMux.HandleFunc("POST /api/refresh", c.handlerRefreshToken)
 	serveMux.HandleFunc("POST /api/revoke", c.handlerRevokeToken)
-	serveMux.HandleFunc("PUT /api/users", c.handlerUpdateUser)
+	serveMux.HandleFunc("POST /api/logout-all", c.RequireAuth(c.handlerLogoutAll))
+	serveMux.HandleFunc("GET /api/sessions", c.RequireAuth(c.handlerGetSessions))
+	serveMux.HandleFunc("DELETE /api/sessions/{sessionId}", c.RequireAuth(c.handlerRevokeSession))
+	serveMux.HandleFunc("POST /api/password/forgot", c.handlerForgotPassword)
+	serveMux.HandleFunc("POST /api/password/reset", c.handlerResetPassword)
+	serveMux.HandleFunc("PUT /api/users", c.RequireAuth(c.handlerUpdateUser))
+	serveMux.HandleFunc("PATCH /api/users/me", c.RequireAuth(c.handlerUpdateProfile))
+	serveMux.HandleFunc("POST /api/users/me/2fa", c.RequireAuth(c.handlerEnrollTOTP))
+	serveMux.HandleFunc("POST /api/users/me/2fa/verify", c.RequireAuth(c.handlerConfirmTOTP))
+	serveMux.HandleFunc("DELETE /api/users/me/2fa", c.RequireAuth(c.handlerDisableTOTP))
+	serveMux.HandleFunc("GET /api/users/verify", c.handlerVerifyEmail)
+	serveMux.HandleFunc("POST /api/users/verify/resend", c.RequireAuth(c.handlerResendVerification))
+	serveMux.HandleFunc("GET /api/users/{userId}", c.handlerGetUser)
+	serveMux.HandleFunc("GET /api/users/{userId}/{resource}", c.OptionalAuth(c.handlerGetUserResource))
+	serveMux.HandleFunc("POST /api/users/{userId}/follow", c.RequireAuth(c.handlerFollowUser))
+	serveMux.HandleFunc("DELETE /api/users/{userId}/follow", c.RequireAuth(c.handlerUnfollowUser))
+	serveMux.HandleFunc("GET /api/timeline", c.RequireAuth(c.handlerGetTimeline))
+	serveMux.HandleFunc("GET /api/hashtags/trending", c.handlerGetTrendingHashtags)
+	serveMux.HandleFunc("GET /api/hashtags/{tag}/chirps", c.OptionalAuth(c.handlerGetHashtagChirps))
+	serveMux.HandleFunc("POST /api/media", c.RequireAuth(c.handlerUploadMedia))
+	serveMux.HandleFunc("GET /api/media/{mediaId}", c.handlerGetMedia)
+	serveMux.HandleFunc("GET /api/media/{mediaId}/thumbnail", c.handlerGetMediaThumbnail)
+	serveMux.HandleFunc("GET /api/notifications", c.RequireAuth(c.handlerGetNotifications))
+	serveMux.HandleFunc("POST /api/notifications/read", c.RequireAuth(c.handlerMarkNotificationsRead))
 	serveMux.HandleFunc("POST /api/polka/webhooks", c.handlerUserUpgradeWebhook)
 	return serveMux, nil
 
@@ -105,6 +597,13 @@ func handlerHealth(rw http.ResponseWriter, req *http.Request) {
 	rw.Write([]byte("OK"))
 }
 
+// handlerJWKS publishes the keys access tokens are signed with so other
+// services can verify them without sharing a secret.
+func (cfg *config) handlerJWKS(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(rw, 200, cfg.Tokens.JWKS())
+}
+
 func (cfg *config) handlerHits(rw http.ResponseWriter, req *http.Request) {
 	rw.Header().Add("Content-Type", "text/html; charset=utf-8")
 	rw.WriteHeader(http.StatusOK)
@@ -142,6 +641,7 @@ func (cfg *config) handlerNewUser(rw http.ResponseWriter, req *http.Request) {
 	type parameters struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Handle   string `json:"handle"`
 	}
 
 	decoder := json.NewDecoder(req.Body)
@@ -154,7 +654,24 @@ func (cfg *config) handlerNewUser(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	if !isValidEmail(params.Email) {
+		respondWithError(rw, 400, "Invalid email")
+		return
+	}
+
+	handle := sql.NullString{}
+
+	if params.Handle != "" {
+		handle.String = strings.ToLower(params.Handle)
+		handle.Valid = true
+
+		if !isValidHandle(handle.String) {
+			respondWithError(rw, 400, "Handle must be 3-30 letters, digits or underscores")
+			return
+		}
+	}
+
+	hashedPassword, err := cfg.Passwords.Hash(req.Context(), params.Password)
 
 	if err != nil {
 		respondWithError(rw, 500, err.Error())
@@ -164,27 +681,44 @@ func (cfg *config) handlerNewUser(rw http.ResponseWriter, req *http.Request) {
 	user, err := cfg.Db.CreateUser(context.Background(), database.CreateUserParams{
 		Email:          params.Email,
 		HashedPassword: hashedPassword,
+		Handle:         handle,
 	})
 
+	if isUniqueViolation(err, "users_handle_key") {
+		respondWithError(rw, 409, "Handle already taken")
+		return
+	}
+
+	if isUniqueViolation(err, "users_email_lower_key") {
+		respondWithError(rw, 409, "Email already in use")
+		return
+	}
+
 	if err != nil {
 		respondWithError(rw, 500, "Failed to create user")
 		return
 	}
 
+	cfg.sendVerificationEmailOrLog(user)
+
 	type userJson struct {
-		Id          string `json:"id"`
-		CreatedAt   string `json:"created_at"`
-		UpdatedAt   string `json:"updated_at"`
-		Email       string `json:"email"`
-		IsChirpyRed bool   `json:"is_chirpy_red"`
+		Id            string `json:"id"`
+		CreatedAt     string `json:"created_at"`
+		UpdatedAt     string `json:"updated_at"`
+		Email         string `json:"email"`
+		Handle        string `json:"handle,omitempty"`
+		EmailVerified bool   `json:"email_verified"`
+		IsChirpyRed   bool   `json:"is_chirpy_red"`
 	}
 
 	userBody := userJson{
-		Id:          user.ID.String(),
-		CreatedAt:   user.CreatedAt.String(),
-		UpdatedAt:   user.UpdatedAt.String(),
-		Email:       user.Email,
-		IsChirpyRed: user.IsChirpyRed.Bool,
+		Id:            user.ID.String(),
+		CreatedAt:     user.CreatedAt.String(),
+		UpdatedAt:     user.UpdatedAt.String(),
+		Email:         user.Email,
+		Handle:        user.Handle.String,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		IsChirpyRed:   user.IsChirpyRed.Bool,
 	}
 
 	respondWithJSON(rw, 201, userBody)
@@ -192,8 +726,9 @@ func (cfg *config) handlerNewUser(rw http.ResponseWriter, req *http.Request) {
 
 func (cfg *config) handlerLogin(rw http.ResponseWriter, req *http.Request) {
 	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email      string `json:"email"`
+		Password   string `json:"password"`
+		DeviceName string `json:"device_name"`
 	}
 
 	decoder := json.NewDecoder(req.Body)
@@ -206,37 +741,92 @@ func (cfg *config) handlerLogin(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	accountKey := loginAccountKey(params.Email)
+
+	attempt, wait, locked, err := cfg.beginLoginAttempt(req, accountKey)
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	if wait > 0 {
+		respondLoginBlocked(rw, wait, locked)
+		return
+	}
+
 	user, err := cfg.Db.GetUserByEmail(context.Background(), params.Email)
 
 	if err != nil {
+		cfg.loginFailed(req, attempt, uuid.NullUUID{})
 		respondWithError(rw, 401, "Incorrect email or password")
 		return
 	}
 
-	err = auth.CheckPasswordHash(params.Password, user.HashedPassword)
+	err = cfg.Passwords.Verify(req.Context(), params.Password, user.HashedPassword)
 	if err != nil {
+		cfg.loginFailed(req, attempt, uuid.NullUUID{UUID: user.ID, Valid: true})
 		respondWithError(rw, 401, "Incorrect email or password")
 		return
 	}
 
-	token, err := auth.MakeJWT(user.ID, cfg.Secret)
+	if cfg.Passwords.NeedsRehash(user.HashedPassword) {
+		cfg.rehashPassword(req.Context(), user, params.Password)
+	}
 
-	if err != nil {
+	cfg.completeLogin(rw, req, user, params.DeviceName, &attempt)
+}
+
+// completeLogin finishes a sign-in once the user's first factor checks out.
+// Users with two-factor authentication get a challenge instead of tokens,
+// and the account's failed logins, when attempt is set, are only cleared
+// once the second factor is right as well; until then only the password's
+// own attempt is taken back.
+func (cfg *config) completeLogin(rw http.ResponseWriter, req *http.Request, user database.User, deviceName string, attempt *loginAttempt) {
+	if user.SuspendedAt.Valid {
+		respondWithError(rw, 403, "Account suspended")
+		return
+	}
+
+	credential, err := cfg.Db.GetTOTPCredential(context.Background(), user.ID)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		respondWithError(rw, 500, err.Error())
 		return
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
+	if err == nil && credential.ConfirmedAt.Valid {
+		if attempt != nil {
+			cfg.uncountLoginAttempt(*attempt)
+		}
+
+		cfg.respondWithMFAChallenge(rw, user, deviceName)
+		return
+	}
+
+	if attempt != nil {
+		cfg.loginSucceeded(*attempt)
+	}
+
+	cfg.respondWithLogin(rw, req, user, deviceName)
+}
+
+// respondWithLogin starts a session for a user who has proven who they are
+// and returns the access and refresh tokens.
+func (cfg *config) respondWithLogin(rw http.ResponseWriter, req *http.Request, user database.User, deviceName string) {
+	if user.SuspendedAt.Valid {
+		respondWithError(rw, 403, "Account suspended")
+		return
+	}
+
+	session, refreshToken, err := cfg.startSession(req, user.ID, deviceName)
 
 	if err != nil {
 		respondWithError(rw, 500, err.Error())
 		return
 	}
 
-	_, err = cfg.Db.CreateRefreshToken(context.Background(), database.CreateRefreshTokenParams{
-		Token:  refreshToken,
-		UserID: user.ID,
-	})
+	token, err := cfg.Tokens.MakeJWT(user.ID, userClaims(user, session.ID))
 
 	if err != nil {
 		respondWithError(rw, 500, err.Error())
@@ -250,6 +840,7 @@ func (cfg *config) handlerLogin(rw http.ResponseWriter, req *http.Request) {
 		Email        string `json:"email"`
 		Token        string `json:"token"`
 		RefreshToken string `json:"refresh_token"`
+		SessionId    string `json:"session_id"`
 		IsChirpyRed  bool   `json:"is_chirpy_red"`
 	}
 
@@ -260,74 +851,53 @@ func (cfg *config) handlerLogin(rw http.ResponseWriter, req *http.Request) {
 		Email:        user.Email,
 		Token:        token,
 		RefreshToken: refreshToken,
+		SessionId:    session.ID.String(),
 		IsChirpyRed:  user.IsChirpyRed.Bool,
 	}
 
 	respondWithJSON(rw, 200, userBody)
-
 }
 
+// handlerUpdateUser is the older PUT form of handlerUpdateProfile. Empty
+// fields are left as they are, and a new email or password needs
+// current_password all the same.
 func (cfg *config) handlerUpdateUser(rw http.ResponseWriter, req *http.Request) {
-	token, err := auth.GetBearerToken(req.Header)
-
-	if err != nil {
-		respondWithError(rw, 401, "Invalid token")
-		return
-	}
-
-	userUUID, err := auth.ValidateJWT(token, cfg.Secret)
-
-	if err != nil {
-		respondWithError(rw, 401, "Invalid token")
-		return
-	}
-
 	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email           string `json:"email"`
+		Password        string `json:"password"`
+		CurrentPassword string `json:"current_password"`
 	}
 
 	params := parameters{}
 
 	decoder := json.NewDecoder(req.Body)
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 
 	if err != nil {
 		respondWithError(rw, 500, "Could not decode request body.")
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
-
-	if err != nil {
-		respondWithError(rw, 500, "Password could not be hashed")
-		return
-	}
-
-	user, err := cfg.Db.UpdateUserEmailAndPasswordById(context.Background(), database.UpdateUserEmailAndPasswordByIdParams{
-		ID:             userUUID,
-		Email:          params.Email,
-		HashedPassword: hashedPassword,
-	})
+	patch := mergePatch{}
 
-	if err != nil {
-		respondWithError(rw, 500, err.Error())
-		return
-	}
+	for name, value := range map[string]string{
+		"email":            params.Email,
+		"password":         params.Password,
+		"current_password": params.CurrentPassword,
+	} {
+		if value == "" {
+			continue
+		}
 
-	type responseBody struct {
-		Id          uuid.UUID `json:"id"`
-		Email       string    `json:"email"`
-		IsChirpyRed bool      `json:"is_chirpy_red"`
-	}
+		patch[name], err = json.Marshal(value)
 
-	response := responseBody{
-		Id:          user.ID,
-		Email:       user.Email,
-		IsChirpyRed: user.IsChirpyRed.Bool,
+		if err != nil {
+			respondWithError(rw, 500, err.Error())
+			return
+		}
 	}
 
-	respondWithJSON(rw, 200, response)
+	cfg.applyProfilePatch(rw, req, patch)
 }
 
 func (cfg *config) handlerUserUpgradeWebhook(rw http.ResponseWriter, req *http.Request) {
@@ -372,7 +942,21 @@ func (cfg *config) handlerUserUpgradeWebhook(rw http.ResponseWriter, req *http.R
 		return
 	}
 
-	_, err = cfg.Db.UpgradeUserById(context.Background(), parsedId)
+	err = cfg.withTx(func(q *database.Queries) error {
+		user, err := q.GetUserById(context.Background(), parsedId)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = q.UpgradeUserById(context.Background(), parsedId)
+
+		if err != nil || user.IsChirpyRed.Bool {
+			return err
+		}
+
+		return notify(q, notificationChirpyRed, uuid.NullUUID{}, uuid.NullUUID{}, parsedId)
+	})
 
 	if err != nil {
 		respondWithError(rw, 404, err.Error())
@@ -383,6 +967,9 @@ func (cfg *config) handlerUserUpgradeWebhook(rw http.ResponseWriter, req *http.R
 
 }
 
+// handlerRefreshToken trades a refresh token for a new access token and a new
+// refresh token in the same session. Each refresh token works once; seeing a
+// retired one again means it was copied, so the whole session is revoked.
 func (cfg *config) handlerRefreshToken(rw http.ResponseWriter, req *http.Request) {
 	refreshToken, err := auth.GetBearerToken(req.Header)
 
@@ -393,19 +980,90 @@ func (cfg *config) handlerRefreshToken(rw http.ResponseWriter, req *http.Request
 
 	dbRefreshToken, err := cfg.Db.GetRefreshTokenByToken(context.Background(), refreshToken)
 
-	if err != nil || dbRefreshToken.RevokedAt.Valid || time.Until(dbRefreshToken.ExpiresAt) <= 0 {
+	if err != nil {
 		respondWithError(rw, 401, "Missing token")
 		return
 	}
 
-	jwtToken, err := auth.MakeJWT(dbRefreshToken.UserID, cfg.Secret)
+	if dbRefreshToken.RotatedAt.Valid {
+		_, err = cfg.Db.RevokeSession(context.Background(), database.RevokeSessionParams{
+			ID:     dbRefreshToken.SessionID,
+			UserID: dbRefreshToken.UserID,
+		})
+
+		if err != nil {
+			respondWithError(rw, 500, err.Error())
+			return
+		}
+
+		cfg.recordSecurityEvent(req, dbRefreshToken.UserID, securityEventRefreshTokenReuse, uuid.NullUUID{UUID: dbRefreshToken.SessionID, Valid: true})
+
+		respondWithError(rw, 401, "Refresh token reuse detected")
+		return
+	}
+
+	if dbRefreshToken.RevokedAt.Valid || time.Until(dbRefreshToken.ExpiresAt) <= 0 {
+		respondWithError(rw, 401, "Missing token")
+		return
+	}
+
+	user, err := cfg.Db.GetUserById(context.Background(), dbRefreshToken.UserID)
+
+	if err != nil {
+		respondWithError(rw, 401, "Missing token")
+		return
+	}
+
+	if user.SuspendedAt.Valid {
+		respondWithError(rw, 403, "Account suspended")
+		return
+	}
+
+	jwtToken, err := cfg.Tokens.MakeJWT(user.ID, userClaims(user, dbRefreshToken.SessionID))
 
 	if err != nil {
 		respondWithError(rw, 500, "Could not generate JWT token")
 		return
 	}
 
-	err = cfg.Db.RefreshTokenByToken(context.Background(), refreshToken)
+	newRefreshToken, err := auth.MakeRefreshToken()
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	err = cfg.withTx(func(q *database.Queries) error {
+		// Only one of several concurrent refreshes with the same token wins.
+		_, err := q.RotateRefreshToken(context.Background(), database.RotateRefreshTokenParams{
+			Token: refreshToken,
+			Now:   time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = q.CreateRefreshToken(context.Background(), database.CreateRefreshTokenParams{
+			Token:     newRefreshToken,
+			UserID:    dbRefreshToken.UserID,
+			SessionID: dbRefreshToken.SessionID,
+			ExpiresAt: time.Now().UTC().Add(cfg.RefreshTokenTTL),
+		})
+		if err != nil {
+			return err
+		}
+
+		return q.TouchSession(context.Background(), database.TouchSessionParams{
+			ID:        dbRefreshToken.SessionID,
+			UserAgent: truncate(req.UserAgent(), maxUserAgentLength),
+			IpAddress: clientIP(req),
+		})
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(rw, 401, "Missing token")
+		return
+	}
 
 	if err != nil {
 		respondWithError(rw, 500, err.Error())
@@ -413,14 +1071,18 @@ func (cfg *config) handlerRefreshToken(rw http.ResponseWriter, req *http.Request
 	}
 
 	type jsonBody struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	respondWithJSON(rw, 200, jsonBody{
-		Token: jwtToken,
+		Token:        jwtToken,
+		RefreshToken: newRefreshToken,
 	})
 }
 
+// handlerRevokeToken logs out the session a refresh token belongs to. Access
+// tokens already issued to it stay valid until they expire.
 func (cfg *config) handlerRevokeToken(rw http.ResponseWriter, req *http.Request) {
 	token, err := auth.GetBearerToken(req.Header)
 
@@ -430,6 +1092,7 @@ func (cfg *config) handlerRevokeToken(rw http.ResponseWriter, req *http.Request)
 	}
 
 	err = cfg.Db.RevokeRefreshTokenByToken(context.Background(), token)
+
 	if err != nil {
 		respondWithError(rw, 500, err.Error())
 		return
@@ -456,6 +1119,114 @@ func cleanChirp(text string) (string, error) {
 	return strings.Join(words, " "), nil
 }
 
+const maxHashtagLength = 50
+
+// extractHashtags returns the distinct, lower-cased tags in a chirp body. A
+// tag starts with # at the beginning of a word and runs over letters, digits
+// and underscores; it needs at least one letter so "#1" stays plain text.
+func extractHashtags(text string) []string {
+	tags := []string{}
+	runes := []rune(text)
+
+	isTagRune := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+	}
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '#' || (i > 0 && (isTagRune(runes[i-1]) || runes[i-1] == '#')) {
+			continue
+		}
+
+		end := i + 1
+		hasLetter := false
+
+		for end < len(runes) && isTagRune(runes[end]) {
+			hasLetter = hasLetter || unicode.IsLetter(runes[end])
+			end++
+		}
+
+		tag := strings.ToLower(string(runes[i+1 : end]))
+
+		if hasLetter && len(tag) <= maxHashtagLength && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+
+		i = end - 1
+	}
+
+	return tags
+}
+
+const (
+	minHandleLength = 3
+	maxHandleLength = 30
+)
+
+func isHandleRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_'
+}
+
+func isValidHandle(handle string) bool {
+	if len(handle) < minHandleLength || len(handle) > maxHandleLength {
+		return false
+	}
+
+	for _, r := range handle {
+		if !isHandleRune(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type mentionMatch struct {
+	Handle string
+	Start  int
+	End    int
+}
+
+// extractMentions finds @handle references in a chirp body. Offsets are rune
+// positions, with End pointing just past the handle.
+func extractMentions(text string) []mentionMatch {
+	mentions := []mentionMatch{}
+	runes := []rune(text)
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' || (i > 0 && (isHandleRune(runes[i-1]) || runes[i-1] == '@')) {
+			continue
+		}
+
+		end := i + 1
+
+		for end < len(runes) && isHandleRune(runes[end]) {
+			end++
+		}
+
+		handle := strings.ToLower(string(runes[i+1 : end]))
+
+		if isValidHandle(handle) {
+			mentions = append(mentions, mentionMatch{Handle: handle, Start: i, End: end})
+		}
+
+		i = end - 1
+	}
+
+	return mentions
+}
+
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
+
+func isForeignKeyViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+
+	return errors.As(err, &pqErr) && pqErr.Code == "23503" && pqErr.Constraint == constraint
+}
+
 func marshalError(message string) ([]byte, error) {
 	type customJSON struct {
 		Error string `json:"error"`
@@ -505,6 +1276,24 @@ func marshallJSON(payload interface{}) ([]byte, error) {
 	return data, nil
 }
 
+func (cfg *config) withTx(fn func(q *database.Queries) error) error {
+	tx, err := cfg.DbConn.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	err = fn(cfg.Db.WithTx(tx))
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func respondWithJSON(rw http.ResponseWriter, code int, payload interface{}) {
 	data, err := marshallJSON(payload)
 	rw.Header().Set("Content-Type", "application/json")
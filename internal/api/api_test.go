@@ -0,0 +1,41 @@
+package api
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestExtractHashtags(t *testing.T) {
+	cases := map[string][]string{
+		"no tags here":                     {},
+		"#Go is great":                     {"go"},
+		"learning #go and #GO again":       {"go"},
+		"mid#word and ##double #1 #v1_2":   {"v1_2"},
+		"punctuation #end. (#paren) #café": {"end", "paren", "café"},
+	}
+
+	for input, expected := range cases {
+		got := extractHashtags(input)
+		if !slices.Equal(got, expected) {
+			t.Fatalf("Expected %v for %q, got %v", expected, input, got)
+		}
+	}
+}
+
+func TestExtractMentions(t *testing.T) {
+	mentions := extractMentions("hi @Alice, meet @bob_2 and email a@b.com or @x @@dup")
+
+	expected := []mentionMatch{
+		{Handle: "alice", Start: 3, End: 9},
+		{Handle: "bob_2", Start: 16, End: 22},
+	}
+
+	if !slices.Equal(mentions, expected) {
+		t.Fatalf("Expected %v, got %v", expected, mentions)
+	}
+
+	mentions = extractMentions("ünïcode @handle")
+	if len(mentions) != 1 || mentions[0].Start != 8 || mentions[0].End != 15 {
+		t.Fatalf("Expected rune offsets 8-15, got %v", mentions)
+	}
+}
@@ -2,27 +2,69 @@ package api
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"slices"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/noueii/go-http-server/internal/auth"
 	"github.com/noueii/go-http-server/internal/database"
 )
 
 type ChirpJSON struct {
+	Id         uuid.UUID   `json:"id"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+	Body       string      `json:"body"`
+	UserId     uuid.UUID   `json:"user_id"`
+	Edited     bool        `json:"edited"`
+	ParentId   *uuid.UUID  `json:"parent_id"`
+	RootId     *uuid.UUID  `json:"root_id"`
+	ReplyCount int32       `json:"reply_count"`
+	LikeCount  int32       `json:"like_count"`
+	LikedByMe  bool        `json:"liked_by_me"`
+	Deleted    bool        `json:"deleted"`
+	Entities   EntityJSON  `json:"entities"`
+	Media      []MediaJSON `json:"media"`
+}
+
+type EntityJSON struct {
+	Mentions []MentionJSON `json:"mentions"`
+}
+
+// MentionJSON locates an @handle in the chirp body. Start and End are rune
+// offsets, End exclusive.
+type MentionJSON struct {
+	Start  int32     `json:"start"`
+	End    int32     `json:"end"`
+	UserId uuid.UUID `json:"user_id"`
+	Handle string    `json:"handle"`
+}
+
+type chirpThreadJSON struct {
+	Ancestors   []ChirpJSON `json:"ancestors"`
+	Chirp       ChirpJSON   `json:"chirp"`
+	Descendants []ChirpJSON `json:"descendants"`
+	Truncated   bool        `json:"truncated"`
+}
+
+const maxThreadDescendants = 500
+
+type ChirpRevisionJSON struct {
 	Id        uuid.UUID `json:"id"`
+	ChirpId   uuid.UUID `json:"chirp_id"`
 	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
 	Body      string    `json:"body"`
-	UserId    uuid.UUID `json:"user_id"`
 }
 
 func (cfg *config) handlerCreateChirp(rw http.ResponseWriter, req *http.Request) {
 	type parameters struct {
-		Body string `json:"body"`
+		Body      string      `json:"body"`
+		InReplyTo string      `json:"in_reply_to"`
+		MediaIds  []uuid.UUID `json:"media_ids"`
 	}
 
 	type validJson struct {
@@ -52,89 +94,346 @@ func (cfg *config) handlerCreateChirp(rw http.ResponseWriter, req *http.Request)
 
 	cleanedBody, _ := cleanChirp(params.Body)
 
-	token, err := auth.GetBearerToken(req.Header)
+	if len(params.MediaIds) > maxChirpMedia {
+		respondWithError(rw, 400, fmt.Sprintf("A chirp can have at most %d media attachments", maxChirpMedia))
+		return
+	}
 
-	if err != nil {
-		respondWithError(rw, 401, "Unauthorized")
+	for i, id := range params.MediaIds {
+		if slices.Contains(params.MediaIds[:i], id) {
+			respondWithError(rw, 400, errInvalidMedia.Error())
+			return
+		}
+	}
+
+	jwtUUID := requestPrincipal(req).UserID
+
+	if cfg.RequireVerifiedEmail {
+		author, err := cfg.Db.GetUserById(context.Background(), jwtUUID)
+
+		if err != nil {
+			respondWithError(rw, 401, "Unauthorized")
+			return
+		}
+
+		if !author.EmailVerifiedAt.Valid {
+			respondWithError(rw, 403, "Verify your email address before chirping")
+			return
+		}
+	}
+
+	parentId := uuid.NullUUID{}
+	rootId := uuid.NullUUID{}
+	parentAuthorId := uuid.NullUUID{}
+
+	if params.InReplyTo != "" {
+		parentUUID, err := uuid.Parse(params.InReplyTo)
+
+		if err != nil {
+			respondWithError(rw, 400, "Invalid in_reply_to")
+			return
+		}
+
+		parent, err := cfg.Db.GetChirpById(context.Background(), parentUUID)
+
+		if err != nil || parent.DeletedAt.Valid {
+			respondWithError(rw, 404, "Parent chirp not found")
+			return
+		}
+
+		parentId = uuid.NullUUID{UUID: parent.ID, Valid: true}
+		parentAuthorId = uuid.NullUUID{UUID: parent.UserID, Valid: true}
+		rootId = parent.RootID
+
+		if !rootId.Valid {
+			rootId = parentId
+		}
+	}
+
+	var chirp database.Chirp
+
+	err = cfg.withTx(func(q *database.Queries) error {
+		chirp, err = q.CreateChirp(context.Background(), database.CreateChirpParams{
+			Body:     cleanedBody,
+			UserID:   jwtUUID,
+			ParentID: parentId,
+			RootID:   rootId,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		err = saveHashtags(q, chirp, false)
+
+		if err != nil {
+			return err
+		}
+
+		err = attachMedia(q, chirp, params.MediaIds)
+
+		if err != nil {
+			return err
+		}
+
+		err = saveMentions(q, chirp, false)
+
+		if err != nil || !parentAuthorId.Valid {
+			return err
+		}
+
+		return notify(q, notificationReply, uuid.NullUUID{UUID: chirp.UserID, Valid: true}, uuid.NullUUID{UUID: chirp.ID, Valid: true}, parentAuthorId.UUID)
+	})
+
+	if errors.Is(err, errInvalidMedia) {
+		respondWithError(rw, 400, err.Error())
 		return
 	}
 
-	jwtUUID, err := auth.ValidateJWT(token, cfg.Secret)
+	// The parent was deleted after it was looked up above.
+	if isForeignKeyViolation(err, "chirps_parent_id_fkey") {
+		respondWithError(rw, 404, "Parent chirp not found")
+		return
+	}
 
 	if err != nil {
-		respondWithError(rw, 401, "Unauthorized")
+		respondWithError(rw, 500, err.Error())
 		return
 	}
 
-	chirp, err := cfg.Db.CreateChirp(context.Background(), database.CreateChirpParams{
-		Body:   cleanedBody,
-		UserID: jwtUUID,
-	})
+	cfg.Fanout.ChirpCreated(chirp)
+
+	response := []ChirpJSON{newChirpJSON(chirp)}
+
+	err = cfg.enrichChirps(uuid.NullUUID{}, response)
 
 	if err != nil {
 		respondWithError(rw, 500, err.Error())
+		return
 	}
 
-	respondWithJSON(rw, 201, ChirpJSON{
-		Id:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body:      chirp.Body,
-		UserId:    chirp.UserID,
-	})
+	respondWithJSON(rw, 201, response[0])
 
 }
 
-func (cfg *config) handlerGetAllChirps(rw http.ResponseWriter, req *http.Request) {
-	authorId := req.URL.Query().Get("author_id")
+type chirpPageJSON struct {
+	Chirps     []ChirpJSON `json:"chirps"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	PrevCursor string      `json:"prev_cursor,omitempty"`
+}
 
-	chirps := make([]database.Chirp, 0)
+func newChirpJSON(chirp database.Chirp) ChirpJSON {
+	return ChirpJSON{
+		Id:         chirp.ID,
+		CreatedAt:  chirp.CreatedAt,
+		UpdatedAt:  chirp.UpdatedAt,
+		Body:       chirp.Body,
+		UserId:     chirp.UserID,
+		Edited:     chirp.Edited,
+		ParentId:   nullUUIDPtr(chirp.ParentID),
+		RootId:     nullUUIDPtr(chirp.RootID),
+		ReplyCount: chirp.ReplyCount,
+		LikeCount:  chirp.LikeCount,
+		Deleted:    chirp.DeletedAt.Valid,
+		Entities:   EntityJSON{Mentions: []MentionJSON{}},
+		Media:      []MediaJSON{},
+	}
+}
 
-	if authorId == "" {
-		chirpsDB, err := cfg.Db.GetAllChirps(context.Background())
+// enrichChirps fills in the mention entities, media attachments and, for
+// signed-in callers, LikedByMe for every chirp in the given groups, one query
+// per field.
+func (cfg *config) enrichChirps(userId uuid.NullUUID, groups ...[]ChirpJSON) error {
+	ids := []uuid.UUID{}
 
-		if err != nil {
-			respondWithError(rw, 500, err.Error())
-			return
+	for _, group := range groups {
+		for _, chirp := range group {
+			ids = append(ids, chirp.Id)
 		}
+	}
 
-		chirps = chirpsDB
+	if len(ids) == 0 {
+		return nil
+	}
 
-	} else {
-		authorUUID, err := uuid.Parse(authorId)
+	mentionRows, err := cfg.Db.GetMentionsByChirpIds(context.Background(), ids)
+
+	if err != nil {
+		return err
+	}
+
+	mentions := make(map[uuid.UUID][]MentionJSON)
+
+	for _, row := range mentionRows {
+		mentions[row.ChirpID] = append(mentions[row.ChirpID], MentionJSON{
+			Start:  row.StartOffset,
+			End:    row.EndOffset,
+			UserId: row.UserID,
+			Handle: row.Handle.String,
+		})
+	}
+
+	mediaRows, err := cfg.Db.GetMediaByChirpIds(context.Background(), ids)
+
+	if err != nil {
+		return err
+	}
+
+	attachments := make(map[uuid.UUID][]MediaJSON)
+
+	for _, row := range mediaRows {
+		attachments[row.ChirpID.UUID] = append(attachments[row.ChirpID.UUID], newMediaJSON(row))
+	}
+
+	liked := make(map[uuid.UUID]bool)
+
+	if userId.Valid {
+		likedIds, err := cfg.Db.GetLikedChirpIds(context.Background(), database.GetLikedChirpIdsParams{
+			UserID:   userId.UUID,
+			ChirpIds: ids,
+		})
 
 		if err != nil {
-			respondWithError(rw, 500, err.Error())
-			return
+			return err
 		}
 
-		chirpsDB, err := cfg.Db.GetAllChirpsByAuthorId(context.Background(), authorUUID)
+		for _, id := range likedIds {
+			liked[id] = true
+		}
+	}
+
+	for _, group := range groups {
+		for i := range group {
+			group[i].LikedByMe = liked[group[i].Id]
+
+			if chirpMentions, ok := mentions[group[i].Id]; ok {
+				group[i].Entities.Mentions = chirpMentions
+			}
+
+			if chirpMedia, ok := attachments[group[i].Id]; ok {
+				group[i].Media = chirpMedia
+			}
+		}
+	}
+
+	return nil
+}
+
+func nullUUIDPtr(id uuid.NullUUID) *uuid.UUID {
+	if !id.Valid {
+		return nil
+	}
+
+	return &id.UUID
+}
+
+func (cfg *config) handlerGetAllChirps(rw http.ResponseWriter, req *http.Request) {
+	authorId := uuid.NullUUID{}
+
+	if req.URL.Query().Get("author_id") != "" {
+		authorUUID, err := uuid.Parse(req.URL.Query().Get("author_id"))
 
 		if err != nil {
-			respondWithError(rw, 500, err.Error())
+			respondWithError(rw, 400, "Invalid author id")
 			return
 		}
 
-		chirps = chirpsDB
+		authorId = uuid.NullUUID{UUID: authorUUID, Valid: true}
+	}
+
+	cfg.respondWithChirpsPage(rw, req, authorId, uuid.NullUUID{})
+}
+
+func (cfg *config) handlerGetChirpReplies(rw http.ResponseWriter, req *http.Request) {
+	chirpUUID, err := uuid.Parse(req.PathValue("chirpId"))
 
+	if err != nil {
+		respondWithError(rw, 404, "Chirp not found")
+		return
 	}
 
-	response := make([]ChirpJSON, 0)
+	_, err = cfg.Db.GetChirpById(context.Background(), chirpUUID)
 
-	for _, chirp := range chirps {
-		response = append(response, ChirpJSON{
-			Id:        chirp.ID,
-			CreatedAt: chirp.CreatedAt,
-			UpdatedAt: chirp.UpdatedAt,
-			Body:      chirp.Body,
-			UserId:    chirp.UserID,
+	if err != nil {
+		respondWithError(rw, 404, "Chirp not found")
+		return
+	}
+
+	cfg.respondWithChirpsPage(rw, req, uuid.NullUUID{}, uuid.NullUUID{UUID: chirpUUID, Valid: true})
+}
+
+func (cfg *config) respondWithChirpsPage(rw http.ResponseWriter, req *http.Request, authorId, parentId uuid.NullUUID) {
+	query := req.URL.Query()
+
+	limit, err := parsePageSize(query.Get("limit"))
+
+	if err != nil {
+		respondWithError(rw, 400, err.Error())
+		return
+	}
+
+	desc, err := parseSort(query.Get("sort"))
+
+	if err != nil {
+		respondWithError(rw, 400, err.Error())
+		return
+	}
+
+	cursor, err := decodeCursor(query.Get("cursor"))
+
+	if err != nil {
+		respondWithError(rw, 400, err.Error())
+		return
+	}
+
+	if cursor != nil && cursor.Prev {
+		desc = !desc
+	}
+
+	var chirps []database.Chirp
+
+	if desc {
+		chirps, err = cfg.Db.GetChirpsPageDesc(context.Background(), database.GetChirpsPageDescParams{
+			AuthorID:        authorId,
+			ParentID:        parentId,
+			CursorCreatedAt: cursor.createdAt(),
+			CursorID:        cursor.id(),
+			PageSize:        limit + 1,
+		})
+	} else {
+		chirps, err = cfg.Db.GetChirpsPageAsc(context.Background(), database.GetChirpsPageAscParams{
+			AuthorID:        authorId,
+			ParentID:        parentId,
+			CursorCreatedAt: cursor.createdAt(),
+			CursorID:        cursor.id(),
+			PageSize:        limit + 1,
 		})
 	}
 
-	sorting := req.URL.Query().Get("sort")
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	chirps, next, prev := buildPage(chirps, limit, cursor, func(chirp database.Chirp) pageCursor {
+		return pageCursor{CreatedAt: chirp.CreatedAt, Id: chirp.ID}
+	})
+
+	response := chirpPageJSON{
+		Chirps:     make([]ChirpJSON, 0, len(chirps)),
+		NextCursor: next,
+		PrevCursor: prev,
+	}
 
-	if sorting == "desc" {
-		slices.Reverse(response)
+	for _, chirp := range chirps {
+		response.Chirps = append(response.Chirps, newChirpJSON(chirp))
+	}
+
+	err = cfg.enrichChirps(optionalUserId(req), response.Chirps)
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
 	}
 
 	respondWithJSON(rw, 200, response)
@@ -156,29 +455,26 @@ func (cfg *config) handlerGetChirp(rw http.ResponseWriter, req *http.Request) {
 
 	chirp, err := cfg.Db.GetChirpById(context.Background(), id)
 
-	if err != nil {
-		respondWithError(rw, 404, err.Error())
+	if err != nil || chirp.DeletedAt.Valid {
+		respondWithError(rw, 404, "Chirp not found")
 		return
 	}
 
-	respondWithJSON(rw, 200, ChirpJSON{
-		Id:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body:      chirp.Body,
-		UserId:    chirp.UserID,
-	})
+	response := []ChirpJSON{newChirpJSON(chirp)}
 
-}
-
-func (cfg *config) handlerDeleteChirp(rw http.ResponseWriter, req *http.Request) {
-	jwtToken, err := auth.GetBearerToken(req.Header)
+	err = cfg.enrichChirps(optionalUserId(req), response)
 
 	if err != nil {
-		respondWithError(rw, 401, "Unauthorized")
+		respondWithError(rw, 500, err.Error())
 		return
 	}
 
+	respondWithJSON(rw, 200, response[0])
+
+}
+
+func (cfg *config) handlerDeleteChirp(rw http.ResponseWriter, req *http.Request) {
+	userId := requestPrincipal(req).UserID
 	chirpId := req.PathValue("chirpId")
 
 	if chirpId == "" {
@@ -195,29 +491,245 @@ func (cfg *config) handlerDeleteChirp(rw http.ResponseWriter, req *http.Request)
 
 	chirp, err := cfg.Db.GetChirpById(context.Background(), chirpUUID)
 
+	if err != nil || chirp.DeletedAt.Valid {
+		respondWithError(rw, 404, "Chirp not found")
+		return
+	}
+
+	if userId != chirp.UserID {
+		respondWithError(rw, 403, "Forbidden")
+		return
+	}
+
+	attachments, err := cfg.Db.GetMediaByChirpIds(context.Background(), []uuid.UUID{chirpUUID})
+
 	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	var deleted int64
+
+	// Locking the chirp makes a reply being posted to it wait on its foreign
+	// key check, so the reply either lands before the check for replies or
+	// fails once the chirp is gone.
+	err = cfg.withTx(func(q *database.Queries) error {
+		_, err := q.LockChirpById(context.Background(), chirpUUID)
+		if err != nil {
+			return err
+		}
+
+		deleted, err = q.DeleteChirpWithoutReplies(context.Background(), chirpUUID)
+		if err != nil || deleted > 0 {
+			return err
+		}
+
+		return q.TombstoneChirpById(context.Background(), chirpUUID)
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
 		respondWithError(rw, 404, "Chirp not found")
 		return
 	}
 
-	userId, err := auth.ValidateJWT(jwtToken, cfg.Secret)
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	if deleted == 0 {
+		cfg.Fanout.ChirpDeleted(chirpUUID)
+	}
+
+	cfg.deleteMediaBlobs(attachments...)
+
+	rw.WriteHeader(204)
+}
+
+func (cfg *config) handlerUpdateChirp(rw http.ResponseWriter, req *http.Request) {
+	userId := requestPrincipal(req).UserID
+
+	chirpUUID, err := uuid.Parse(req.PathValue("chirpId"))
 
 	if err != nil {
-		respondWithError(rw, 403, "Unauthorized")
+		respondWithError(rw, 404, "Chirp not found")
 		return
 	}
 
-	if userId != chirp.UserID {
-		respondWithError(rw, 403, "Unauthorized")
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	decoder := json.NewDecoder(req.Body)
+	params := parameters{}
+
+	err = decoder.Decode(&params)
+
+	if err != nil {
+		respondWithError(rw, 400, "Something went wrong")
+		return
+	}
+
+	if !isValidChirp(params.Body) {
+		respondWithError(rw, 400, "Chirp is too long")
+		return
+	}
+
+	cleanedBody, _ := cleanChirp(params.Body)
+
+	chirp, err := cfg.Db.GetChirpById(context.Background(), chirpUUID)
+
+	if err != nil || chirp.DeletedAt.Valid {
+		respondWithError(rw, 404, "Chirp not found")
 		return
 	}
 
-	err = cfg.Db.DeleteChirpById(context.Background(), chirpUUID)
+	if chirp.UserID != userId {
+		respondWithError(rw, 403, "Forbidden")
+		return
+	}
+
+	if chirp.Body != cleanedBody {
+		err = cfg.withTx(func(q *database.Queries) error {
+			chirp, err = q.UpdateChirpBody(context.Background(), database.UpdateChirpBodyParams{
+				ID:     chirpUUID,
+				UserID: userId,
+				Body:   cleanedBody,
+			})
+
+			if err != nil {
+				return err
+			}
+
+			err = saveHashtags(q, chirp, true)
+
+			if err != nil {
+				return err
+			}
+
+			return saveMentions(q, chirp, true)
+		})
+
+		if err == sql.ErrNoRows {
+			respondWithError(rw, 404, "Chirp not found")
+			return
+		}
+
+		if err != nil {
+			respondWithError(rw, 500, err.Error())
+			return
+		}
+	}
+
+	response := []ChirpJSON{newChirpJSON(chirp)}
+
+	err = cfg.enrichChirps(uuid.NullUUID{UUID: userId, Valid: true}, response)
 
 	if err != nil {
 		respondWithError(rw, 500, err.Error())
 		return
 	}
 
-	rw.WriteHeader(204)
+	respondWithJSON(rw, 200, response[0])
+}
+
+func (cfg *config) handlerGetChirpRevisions(rw http.ResponseWriter, req *http.Request) {
+	chirpUUID, err := uuid.Parse(req.PathValue("chirpId"))
+
+	if err != nil {
+		respondWithError(rw, 404, "Chirp not found")
+		return
+	}
+
+	_, err = cfg.Db.GetChirpById(context.Background(), chirpUUID)
+
+	if err != nil {
+		respondWithError(rw, 404, "Chirp not found")
+		return
+	}
+
+	revisions, err := cfg.Db.GetChirpRevisionsByChirpId(context.Background(), chirpUUID)
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	response := make([]ChirpRevisionJSON, 0, len(revisions))
+
+	for _, revision := range revisions {
+		response = append(response, ChirpRevisionJSON{
+			Id:        revision.ID,
+			ChirpId:   revision.ChirpID,
+			CreatedAt: revision.CreatedAt,
+			Body:      revision.Body,
+		})
+	}
+
+	respondWithJSON(rw, 200, response)
+}
+
+func (cfg *config) handlerGetChirpThread(rw http.ResponseWriter, req *http.Request) {
+	chirpUUID, err := uuid.Parse(req.PathValue("chirpId"))
+
+	if err != nil {
+		respondWithError(rw, 404, "Chirp not found")
+		return
+	}
+
+	chirp, err := cfg.Db.GetChirpById(context.Background(), chirpUUID)
+
+	if err != nil {
+		respondWithError(rw, 404, "Chirp not found")
+		return
+	}
+
+	ancestors, err := cfg.Db.GetChirpAncestors(context.Background(), chirpUUID)
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	descendants, err := cfg.Db.GetChirpDescendants(context.Background(), database.GetChirpDescendantsParams{
+		ParentID: uuid.NullUUID{UUID: chirpUUID, Valid: true},
+		Limit:    maxThreadDescendants + 1,
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	response := chirpThreadJSON{
+		Ancestors:   make([]ChirpJSON, 0, len(ancestors)),
+		Chirp:       newChirpJSON(chirp),
+		Descendants: make([]ChirpJSON, 0, len(descendants)),
+	}
+
+	if len(descendants) > maxThreadDescendants {
+		descendants = descendants[:maxThreadDescendants]
+		response.Truncated = true
+	}
+
+	for _, ancestor := range ancestors {
+		response.Ancestors = append(response.Ancestors, newChirpJSON(ancestor))
+	}
+
+	for _, descendant := range descendants {
+		response.Descendants = append(response.Descendants, newChirpJSON(descendant))
+	}
+
+	current := []ChirpJSON{response.Chirp}
+
+	err = cfg.enrichChirps(optionalUserId(req), response.Ancestors, current, response.Descendants)
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	response.Chirp = current[0]
+
+	respondWithJSON(rw, 200, response)
 }
@@ -0,0 +1,267 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+// timelineBackfillSize is how many of their recent chirps a new follower
+// finds in their timeline.
+const timelineBackfillSize = 200
+
+type PublicUserJSON struct {
+	Id             uuid.UUID `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	Handle         string    `json:"handle,omitempty"`
+	DisplayName    string    `json:"display_name"`
+	Bio            string    `json:"bio"`
+	Location       string    `json:"location"`
+	Website        string    `json:"website"`
+	AvatarUrl      string    `json:"avatar_url,omitempty"`
+	IsChirpyRed    bool      `json:"is_chirpy_red"`
+	FollowerCount  int32     `json:"follower_count"`
+	FollowingCount int32     `json:"following_count"`
+}
+
+type followPageJSON struct {
+	Users      []PublicUserJSON `json:"users"`
+	Total      int32            `json:"total"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+func newPublicUserJSON(user database.User) PublicUserJSON {
+	response := PublicUserJSON{
+		Id:             user.ID,
+		CreatedAt:      user.CreatedAt,
+		Handle:         user.Handle.String,
+		DisplayName:    user.DisplayName,
+		Bio:            user.Bio,
+		Location:       user.Location,
+		Website:        user.Website,
+		IsChirpyRed:    user.IsChirpyRed.Bool,
+		FollowerCount:  user.FollowerCount,
+		FollowingCount: user.FollowingCount,
+	}
+
+	if user.AvatarMediaID.Valid {
+		response.AvatarUrl = "/api/media/" + user.AvatarMediaID.UUID.String()
+	}
+
+	return response
+}
+
+func (cfg *config) handlerFollowUser(rw http.ResponseWriter, req *http.Request) {
+	userId := requestPrincipal(req).UserID
+
+	followeeUUID, err := uuid.Parse(req.PathValue("userId"))
+
+	if err != nil {
+		respondWithError(rw, 404, "User not found")
+		return
+	}
+
+	if followeeUUID == userId {
+		respondWithError(rw, 400, "You cannot follow yourself")
+		return
+	}
+
+	_, err = cfg.Db.GetUserById(context.Background(), followeeUUID)
+
+	if err != nil {
+		respondWithError(rw, 404, "User not found")
+		return
+	}
+
+	err = cfg.withTx(func(q *database.Queries) error {
+		created, err := q.CreateFollow(context.Background(), database.CreateFollowParams{
+			FollowerID: userId,
+			FolloweeID: followeeUUID,
+		})
+
+		if err != nil || created == 0 {
+			return err
+		}
+
+		// The backfill is part of the follow so that it cannot be lost.
+		_, err = q.BackfillTimeline(context.Background(), database.BackfillTimelineParams{
+			UserID:       userId,
+			AuthorID:     followeeUUID,
+			BackfillSize: timelineBackfillSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		return notify(q, notificationFollow, uuid.NullUUID{UUID: userId, Valid: true}, uuid.NullUUID{}, followeeUUID)
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	rw.WriteHeader(204)
+}
+
+func (cfg *config) handlerUnfollowUser(rw http.ResponseWriter, req *http.Request) {
+	userId := requestPrincipal(req).UserID
+
+	followeeUUID, err := uuid.Parse(req.PathValue("userId"))
+
+	if err != nil {
+		respondWithError(rw, 404, "User not found")
+		return
+	}
+
+	deleted, err := cfg.Db.DeleteFollow(context.Background(), database.DeleteFollowParams{
+		FollowerID: userId,
+		FolloweeID: followeeUUID,
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	if deleted > 0 {
+		cfg.Fanout.Unfollowed(userId, followeeUUID)
+	}
+
+	rw.WriteHeader(204)
+}
+
+func (cfg *config) handlerGetFollowers(rw http.ResponseWriter, req *http.Request) {
+	cfg.respondWithFollowPage(rw, req, true)
+}
+
+func (cfg *config) handlerGetFollowing(rw http.ResponseWriter, req *http.Request) {
+	cfg.respondWithFollowPage(rw, req, false)
+}
+
+func (cfg *config) respondWithFollowPage(rw http.ResponseWriter, req *http.Request, followers bool) {
+	userUUID, err := uuid.Parse(req.PathValue("userId"))
+
+	if err != nil {
+		respondWithError(rw, 404, "User not found")
+		return
+	}
+
+	user, err := cfg.Db.GetUserById(context.Background(), userUUID)
+
+	if err != nil {
+		respondWithError(rw, 404, "User not found")
+		return
+	}
+
+	limit, err := parsePageSize(req.URL.Query().Get("limit"))
+
+	if err != nil {
+		respondWithError(rw, 400, err.Error())
+		return
+	}
+
+	cursor, err := decodeCursor(req.URL.Query().Get("cursor"))
+
+	if err != nil || (cursor != nil && cursor.Prev) {
+		respondWithError(rw, 400, "Invalid cursor")
+		return
+	}
+
+	type followRow struct {
+		user     database.User
+		followId uuid.UUID
+		followed time.Time
+	}
+
+	rows := []followRow{}
+
+	if followers {
+		dbRows, err := cfg.Db.GetFollowersPage(context.Background(), database.GetFollowersPageParams{
+			UserID:          userUUID,
+			CursorCreatedAt: cursor.createdAt(),
+			CursorID:        cursor.id(),
+			PageSize:        limit + 1,
+		})
+
+		if err != nil {
+			respondWithError(rw, 500, err.Error())
+			return
+		}
+
+		for _, row := range dbRows {
+			rows = append(rows, followRow{
+				user: database.User{
+					ID:             row.ID,
+					CreatedAt:      row.CreatedAt,
+					IsChirpyRed:    row.IsChirpyRed,
+					FollowerCount:  row.FollowerCount,
+					FollowingCount: row.FollowingCount,
+					Handle:         row.Handle,
+					DisplayName:    row.DisplayName,
+					Bio:            row.Bio,
+					Location:       row.Location,
+					Website:        row.Website,
+					AvatarMediaID:  row.AvatarMediaID,
+				},
+				followId: row.FollowID,
+				followed: row.FollowedAt,
+			})
+		}
+	} else {
+		dbRows, err := cfg.Db.GetFollowingPage(context.Background(), database.GetFollowingPageParams{
+			UserID:          userUUID,
+			CursorCreatedAt: cursor.createdAt(),
+			CursorID:        cursor.id(),
+			PageSize:        limit + 1,
+		})
+
+		if err != nil {
+			respondWithError(rw, 500, err.Error())
+			return
+		}
+
+		for _, row := range dbRows {
+			rows = append(rows, followRow{
+				user: database.User{
+					ID:             row.ID,
+					CreatedAt:      row.CreatedAt,
+					IsChirpyRed:    row.IsChirpyRed,
+					FollowerCount:  row.FollowerCount,
+					FollowingCount: row.FollowingCount,
+					Handle:         row.Handle,
+					DisplayName:    row.DisplayName,
+					Bio:            row.Bio,
+					Location:       row.Location,
+					Website:        row.Website,
+					AvatarMediaID:  row.AvatarMediaID,
+				},
+				followId: row.FollowID,
+				followed: row.FollowedAt,
+			})
+		}
+	}
+
+	rows, next, _ := buildPage(rows, limit, nil, func(row followRow) pageCursor {
+		return pageCursor{CreatedAt: row.followed, Id: row.followId}
+	})
+
+	response := followPageJSON{
+		Users:      make([]PublicUserJSON, 0, len(rows)),
+		Total:      user.FollowingCount,
+		NextCursor: next,
+	}
+
+	if followers {
+		response.Total = user.FollowerCount
+	}
+
+	for _, row := range rows {
+		response.Users = append(response.Users, newPublicUserJSON(row.user))
+	}
+
+	respondWithJSON(rw, 200, response)
+}
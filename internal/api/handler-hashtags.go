@@ -0,0 +1,177 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+const (
+	defaultTrendingWindow = 24 * time.Hour
+	maxTrendingWindow     = 7 * 24 * time.Hour
+	defaultTrendingLimit  = 10
+)
+
+type trendingHashtagJSON struct {
+	Tag   string  `json:"tag"`
+	Uses  int32   `json:"uses"`
+	Score float64 `json:"score"`
+}
+
+// saveHashtags links a chirp to the tags in its body. Edits pass replace so
+// tags that were removed from the body are dropped as well.
+func saveHashtags(q *database.Queries, chirp database.Chirp, replace bool) error {
+	if replace {
+		err := q.DetachHashtagsFromChirp(context.Background(), chirp.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	tags := extractHashtags(chirp.Body)
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	err := q.UpsertHashtags(context.Background(), tags)
+	if err != nil {
+		return err
+	}
+
+	return q.AttachHashtagsToChirp(context.Background(), database.AttachHashtagsToChirpParams{
+		ChirpID:   chirp.ID,
+		CreatedAt: chirp.CreatedAt,
+		Tags:      tags,
+	})
+}
+
+func (cfg *config) handlerGetHashtagChirps(rw http.ResponseWriter, req *http.Request) {
+	tag := strings.ToLower(strings.TrimPrefix(req.PathValue("tag"), "#"))
+
+	if tag == "" {
+		respondWithError(rw, 404, "Hashtag not found")
+		return
+	}
+
+	limit, err := parsePageSize(req.URL.Query().Get("limit"))
+
+	if err != nil {
+		respondWithError(rw, 400, err.Error())
+		return
+	}
+
+	cursor, err := decodeCursor(req.URL.Query().Get("cursor"))
+
+	if err != nil || (cursor != nil && cursor.Prev) {
+		respondWithError(rw, 400, "Invalid cursor")
+		return
+	}
+
+	chirps, err := cfg.Db.GetHashtagChirpsPage(context.Background(), database.GetHashtagChirpsPageParams{
+		Tag:             tag,
+		CursorCreatedAt: cursor.createdAt(),
+		CursorID:        cursor.id(),
+		PageSize:        limit + 1,
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	chirps, next, _ := buildPage(chirps, limit, nil, func(chirp database.Chirp) pageCursor {
+		return pageCursor{CreatedAt: chirp.CreatedAt, Id: chirp.ID}
+	})
+
+	response := chirpPageJSON{
+		Chirps:     make([]ChirpJSON, 0, len(chirps)),
+		NextCursor: next,
+	}
+
+	for _, chirp := range chirps {
+		response.Chirps = append(response.Chirps, newChirpJSON(chirp))
+	}
+
+	err = cfg.enrichChirps(optionalUserId(req), response.Chirps)
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	respondWithJSON(rw, 200, response)
+}
+
+// handlerGetTrendingHashtags ranks tags used within the window. Each use is
+// weighted by exp(-ln2 * age / half_life), so a tag needs recent activity to
+// stay on top. The half-life defaults to a quarter of the window.
+func (cfg *config) handlerGetTrendingHashtags(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	window := defaultTrendingWindow
+
+	if query.Get("window") != "" {
+		parsed, err := time.ParseDuration(query.Get("window"))
+
+		if err != nil || parsed <= 0 || parsed > maxTrendingWindow {
+			respondWithError(rw, 400, "Invalid window")
+			return
+		}
+
+		window = parsed
+	}
+
+	halfLife := window / 4
+
+	if query.Get("half_life") != "" {
+		parsed, err := time.ParseDuration(query.Get("half_life"))
+
+		if err != nil || parsed <= 0 {
+			respondWithError(rw, 400, "Invalid half_life")
+			return
+		}
+
+		halfLife = parsed
+	}
+
+	limit := defaultTrendingLimit
+
+	if query.Get("limit") != "" {
+		parsed, err := strconv.Atoi(query.Get("limit"))
+
+		if err != nil || parsed < 1 {
+			respondWithError(rw, 400, "Invalid limit")
+			return
+		}
+
+		limit = min(parsed, maxPageSize)
+	}
+
+	rows, err := cfg.Db.GetTrendingHashtags(context.Background(), database.GetTrendingHashtagsParams{
+		HalfLifeSeconds: halfLife.Seconds(),
+		WindowSeconds:   window.Seconds(),
+		ResultLimit:     int32(limit),
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	response := make([]trendingHashtagJSON, 0, len(rows))
+
+	for _, row := range rows {
+		response = append(response, trendingHashtagJSON{
+			Tag:   row.Tag,
+			Uses:  row.Uses,
+			Score: row.Score,
+		})
+	}
+
+	respondWithJSON(rw, 200, response)
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+func (cfg *config) handlerLikeChirp(rw http.ResponseWriter, req *http.Request) {
+	userId := requestPrincipal(req).UserID
+
+	chirpUUID, err := uuid.Parse(req.PathValue("chirpId"))
+
+	if err != nil {
+		respondWithError(rw, 404, "Chirp not found")
+		return
+	}
+
+	chirp, err := cfg.Db.GetChirpById(context.Background(), chirpUUID)
+
+	if err != nil || chirp.DeletedAt.Valid {
+		respondWithError(rw, 404, "Chirp not found")
+		return
+	}
+
+	err = cfg.withTx(func(q *database.Queries) error {
+		created, err := q.CreateLike(context.Background(), database.CreateLikeParams{
+			UserID:  userId,
+			ChirpID: chirpUUID,
+		})
+
+		if err != nil || created == 0 {
+			return err
+		}
+
+		return notify(q, notificationLike, uuid.NullUUID{UUID: userId, Valid: true}, uuid.NullUUID{UUID: chirp.ID, Valid: true}, chirp.UserID)
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	rw.WriteHeader(204)
+}
+
+func (cfg *config) handlerUnlikeChirp(rw http.ResponseWriter, req *http.Request) {
+	userId := requestPrincipal(req).UserID
+
+	chirpUUID, err := uuid.Parse(req.PathValue("chirpId"))
+
+	if err != nil {
+		respondWithError(rw, 404, "Chirp not found")
+		return
+	}
+
+	_, err = cfg.Db.DeleteLike(context.Background(), database.DeleteLikeParams{
+		UserID:  userId,
+		ChirpID: chirpUUID,
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	rw.WriteHeader(204)
+}
+
+func (cfg *config) handlerGetUserLikes(rw http.ResponseWriter, req *http.Request) {
+	userUUID, err := uuid.Parse(req.PathValue("userId"))
+
+	if err != nil {
+		respondWithError(rw, 404, "User not found")
+		return
+	}
+
+	_, err = cfg.Db.GetUserById(context.Background(), userUUID)
+
+	if err != nil {
+		respondWithError(rw, 404, "User not found")
+		return
+	}
+
+	limit, err := parsePageSize(req.URL.Query().Get("limit"))
+
+	if err != nil {
+		respondWithError(rw, 400, err.Error())
+		return
+	}
+
+	cursor, err := decodeCursor(req.URL.Query().Get("cursor"))
+
+	if err != nil || (cursor != nil && cursor.Prev) {
+		respondWithError(rw, 400, "Invalid cursor")
+		return
+	}
+
+	rows, err := cfg.Db.GetLikedChirpsPage(context.Background(), database.GetLikedChirpsPageParams{
+		UserID:          userUUID,
+		CursorCreatedAt: cursor.createdAt(),
+		CursorID:        cursor.id(),
+		PageSize:        limit + 1,
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	rows, next, _ := buildPage(rows, limit, nil, func(row database.GetLikedChirpsPageRow) pageCursor {
+		return pageCursor{CreatedAt: row.LikedAt, Id: row.LikeID}
+	})
+
+	response := chirpPageJSON{
+		Chirps:     make([]ChirpJSON, 0, len(rows)),
+		NextCursor: next,
+	}
+
+	for _, row := range rows {
+		response.Chirps = append(response.Chirps, newChirpJSON(database.Chirp{
+			ID:         row.ID,
+			CreatedAt:  row.CreatedAt,
+			UpdatedAt:  row.UpdatedAt,
+			Body:       row.Body,
+			UserID:     row.UserID,
+			Edited:     row.Edited,
+			ParentID:   row.ParentID,
+			RootID:     row.RootID,
+			ReplyCount: row.ReplyCount,
+			LikeCount:  row.LikeCount,
+		}))
+	}
+
+	err = cfg.enrichChirps(optionalUserId(req), response.Chirps)
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	respondWithJSON(rw, 200, response)
+}
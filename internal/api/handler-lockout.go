@@ -0,0 +1,324 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+const (
+	throttleScopeAccount = "account"
+	throttleScopeIP      = "ip"
+)
+
+// throttleRetention is the longest Window of any throttle policy. A row whose
+// last failure is older than that, and that no longer blocks, counts for
+// nothing, so it is deleted rather than kept for every key ever tried.
+const throttleRetention = 24 * time.Hour
+
+const (
+	securityEventLoginThrottled  = "login_throttled"
+	securityEventAccountLocked   = "account_locked"
+	securityEventAccountUnlocked = "account_unlocked"
+)
+
+// throttlePolicy lets FreeAttempts failures through within Window, then
+// blocks for BaseDelay doubling with every further failure up to MaxDelay.
+// From LockoutThreshold failures the block is a LockoutDuration lockout.
+type throttlePolicy struct {
+	FreeAttempts     int32
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutThreshold int32
+	LockoutDuration  time.Duration
+	Window           time.Duration
+}
+
+var accountThrottle = throttlePolicy{
+	FreeAttempts:     5,
+	BaseDelay:        time.Second,
+	MaxDelay:         15 * time.Minute,
+	LockoutThreshold: 20,
+	LockoutDuration:  time.Hour,
+	Window:           24 * time.Hour,
+}
+
+// ipThrottle is looser since many users can share an address. It never
+// locks out.
+var ipThrottle = throttlePolicy{
+	FreeAttempts: 20,
+	BaseDelay:    time.Second,
+	MaxDelay:     15 * time.Minute,
+	Window:       time.Hour,
+}
+
+// blockFor is how long to block after the given number of failures, and
+// whether that counts as a lockout.
+func (p throttlePolicy) blockFor(failures int32) (time.Duration, bool) {
+	if p.LockoutThreshold > 0 && failures >= p.LockoutThreshold {
+		return p.LockoutDuration, true
+	}
+
+	if failures < p.FreeAttempts {
+		return 0, false
+	}
+
+	delay := p.BaseDelay
+
+	for range failures - p.FreeAttempts {
+		delay *= 2
+
+		if delay >= p.MaxDelay {
+			return p.MaxDelay, false
+		}
+	}
+
+	return delay, false
+}
+
+func (p throttlePolicy) locked(failures int32) bool {
+	return p.LockoutThreshold > 0 && failures >= p.LockoutThreshold
+}
+
+func loginAccountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginAttempt is a login that was counted against the account and the
+// client address before its credentials were checked.
+type loginAttempt struct {
+	accountKey string
+	ip         string
+	// failures is the account's failure count, this attempt included.
+	failures int32
+}
+
+type throttleTarget struct {
+	scope  string
+	key    string
+	policy throttlePolicy
+}
+
+func (a loginAttempt) targets() []throttleTarget {
+	return []throttleTarget{
+		{throttleScopeAccount, a.accountKey, accountThrottle},
+		{throttleScopeIP, a.ip, ipThrottle},
+	}
+}
+
+// beginLoginAttempt counts an attempt as failed before anything is verified,
+// so concurrent guesses cannot all get past the limit before the first
+// failure is stored. When the account or address is blocked the attempt is
+// not counted and the remaining wait is returned, along with whether the
+// account is locked rather than slowed down.
+func (cfg *config) beginLoginAttempt(req *http.Request, accountKey string) (loginAttempt, time.Duration, bool, error) {
+	attempt := loginAttempt{accountKey: accountKey, ip: clientIP(req)}
+
+	failures, wait, locked, err := cfg.takeAttempt(attempt.targets())
+
+	if err == nil && wait == 0 {
+		attempt.failures = failures[0]
+	}
+
+	return attempt, wait, locked, err
+}
+
+// takeAttempt counts one attempt against every target, with all of their
+// rows locked while deciding. When any target is blocked nothing is counted
+// and the longest remaining wait is returned, along with whether it is a
+// lockout. Otherwise failures holds each target's new count.
+func (cfg *config) takeAttempt(targets []throttleTarget) ([]int32, time.Duration, bool, error) {
+	err := cfg.Db.DeleteStaleLoginThrottles(context.Background(), time.Now().UTC().Add(-throttleRetention))
+
+	if err != nil {
+		log.Printf("Could not delete stale login throttles: %v", err)
+	}
+
+	failures := make([]int32, len(targets))
+
+	var wait time.Duration
+	var locked bool
+
+	err = cfg.withTx(func(q *database.Queries) error {
+		now := time.Now().UTC()
+		throttles := make([]database.LoginThrottle, len(targets))
+
+		for i, target := range targets {
+			throttle, err := q.LockLoginThrottle(context.Background(), database.LockLoginThrottleParams{
+				Scope: target.scope,
+				Key:   target.key,
+			})
+			if err != nil {
+				return err
+			}
+
+			throttles[i] = throttle
+
+			if !throttle.BlockedUntil.Valid || !throttle.BlockedUntil.Time.After(now) {
+				continue
+			}
+
+			if target.policy.locked(throttle.Failures) {
+				locked = true
+			}
+
+			wait = max(wait, throttle.BlockedUntil.Time.Sub(now))
+		}
+
+		if wait > 0 {
+			return nil
+		}
+
+		for i, target := range targets {
+			failures[i] = throttles[i].Failures + 1
+
+			if throttles[i].LastFailureAt.Before(now.Add(-target.policy.Window)) {
+				failures[i] = 1
+			}
+
+			blockedUntil := sql.NullTime{}
+
+			if block, _ := target.policy.blockFor(failures[i]); block > 0 {
+				blockedUntil = sql.NullTime{Time: now.Add(block), Valid: true}
+			}
+
+			err := q.UpdateLoginThrottle(context.Background(), database.UpdateLoginThrottleParams{
+				Scope:         target.scope,
+				Key:           target.key,
+				Failures:      failures[i],
+				LastFailureAt: now,
+				BlockedUntil:  blockedUntil,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return failures, wait, locked, err
+}
+
+func respondLoginBlocked(rw http.ResponseWriter, wait time.Duration, locked bool) {
+	rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+
+	if locked {
+		respondWithError(rw, 423, "Account temporarily locked after too many failed logins")
+		return
+	}
+
+	respondWithError(rw, 429, "Too many failed logins, please wait before trying again")
+}
+
+// loginFailed gives the user a security event when the failure just
+// counted started throttling or locked their account. userId is unset when
+// the email belongs to nobody.
+func (cfg *config) loginFailed(req *http.Request, attempt loginAttempt, userId uuid.NullUUID) {
+	if !userId.Valid {
+		return
+	}
+
+	switch attempt.failures {
+	case accountThrottle.LockoutThreshold:
+		cfg.recordSecurityEvent(req, userId.UUID, securityEventAccountLocked, uuid.NullUUID{})
+	case accountThrottle.FreeAttempts:
+		cfg.recordSecurityEvent(req, userId.UUID, securityEventLoginThrottled, uuid.NullUUID{})
+	}
+}
+
+// loginSucceeded clears the account's failures. The address only gets this
+// attempt back, since others behind it may still be guessing.
+func (cfg *config) loginSucceeded(attempt loginAttempt) {
+	err := cfg.Db.ClearLoginThrottle(context.Background(), database.ClearLoginThrottleParams{
+		Scope: throttleScopeAccount,
+		Key:   attempt.accountKey,
+	})
+
+	if err != nil {
+		log.Printf("Could not clear login failures for %s: %v", attempt.accountKey, err)
+	}
+
+	err = cfg.Db.RefundLoginAttempt(context.Background(), database.RefundLoginAttemptParams{
+		FreeAttempts: ipThrottle.FreeAttempts,
+		Scope:        throttleScopeIP,
+		Key:          attempt.ip,
+	})
+
+	if err != nil {
+		log.Printf("Could not refund login attempt for %s: %v", attempt.ip, err)
+	}
+}
+
+// uncountLoginAttempt takes back the attempt counted for a correct password
+// when the login still needs a second factor, so that a user close to the
+// limit is not locked out before they can enter a code. The codes are
+// counted as attempts of their own. Since the account was not blocked when
+// the attempt was counted, it is not blocked after it is taken back, unless
+// other attempts were counted meanwhile, which are left alone.
+func (cfg *config) uncountLoginAttempt(attempt loginAttempt) {
+	err := cfg.Db.UncountLoginAttempt(context.Background(), database.UncountLoginAttemptParams{
+		Scope:    throttleScopeAccount,
+		Key:      attempt.accountKey,
+		Failures: attempt.failures,
+	})
+
+	if err != nil {
+		log.Printf("Could not take back login attempt for %s: %v", attempt.accountKey, err)
+	}
+
+	err = cfg.Db.RefundLoginAttempt(context.Background(), database.RefundLoginAttemptParams{
+		FreeAttempts: ipThrottle.FreeAttempts,
+		Scope:        throttleScopeIP,
+		Key:          attempt.ip,
+	})
+
+	if err != nil {
+		log.Printf("Could not refund login attempt for %s: %v", attempt.ip, err)
+	}
+}
+
+// handlerUnlockUser lets an admin lift a lockout or backoff on an account.
+func (cfg *config) handlerUnlockUser(rw http.ResponseWriter, req *http.Request) {
+	userUUID, err := uuid.Parse(req.PathValue("userId"))
+
+	if err != nil {
+		respondWithError(rw, 404, "User not found")
+		return
+	}
+
+	user, err := cfg.Db.GetUserById(context.Background(), userUUID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(rw, 404, "User not found")
+		return
+	}
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	err = cfg.Db.ClearLoginThrottle(context.Background(), database.ClearLoginThrottleParams{
+		Scope: throttleScopeAccount,
+		Key:   loginAccountKey(user.Email),
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	cfg.recordSecurityEvent(req, user.ID, securityEventAccountUnlocked, uuid.NullUUID{})
+
+	rw.WriteHeader(204)
+}
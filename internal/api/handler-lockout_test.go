@@ -0,0 +1,62 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottlePolicyBlockFor(t *testing.T) {
+	policy := throttlePolicy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         10 * time.Second,
+		LockoutThreshold: 10,
+		LockoutDuration:  time.Hour,
+	}
+
+	tests := []struct {
+		failures int32
+		block    time.Duration
+		locked   bool
+	}{
+		{1, 0, false},
+		{2, 0, false},
+		{3, time.Second, false},
+		{4, 2 * time.Second, false},
+		{5, 4 * time.Second, false},
+		{6, 8 * time.Second, false},
+		{7, 10 * time.Second, false},
+		{9, 10 * time.Second, false},
+		{10, time.Hour, true},
+		{50, time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		block, locked := policy.blockFor(tt.failures)
+		if block != tt.block || locked != tt.locked {
+			t.Errorf("%d failures: got (%s, %v), want (%s, %v)", tt.failures, block, locked, tt.block, tt.locked)
+		}
+	}
+
+	ip := policy
+	ip.LockoutThreshold = 0
+
+	block, locked := ip.blockFor(1000)
+	if block != ip.MaxDelay || locked {
+		t.Errorf("without a lockout threshold got (%s, %v)", block, locked)
+	}
+}
+
+func TestLoginAccountKey(t *testing.T) {
+	if loginAccountKey("  Walt@Example.com ") != "walt@example.com" {
+		t.Fatal("account key is not normalised")
+	}
+}
+
+func TestThrottleRetentionCoversEveryWindow(t *testing.T) {
+	for _, policy := range []throttlePolicy{accountThrottle, ipThrottle, resetEmailThrottle, resetIPThrottle} {
+		if policy.Window > throttleRetention || policy.LockoutDuration > throttleRetention || policy.MaxDelay > throttleRetention {
+			t.Errorf("policy %+v outlives throttleRetention %s", policy, throttleRetention)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/database"
+	"github.com/noueii/go-http-server/internal/media"
+	"github.com/noueii/go-http-server/internal/storage"
+)
+
+const (
+	defaultMediaMaxBytes = 5 << 20
+	maxAltTextLength     = 1000
+	maxChirpMedia        = 4
+)
+
+var errInvalidMedia = errors.New("Invalid media_ids")
+
+type MediaJSON struct {
+	Id           uuid.UUID `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	ContentType  string    `json:"content_type"`
+	Width        int32     `json:"width"`
+	Height       int32     `json:"height"`
+	AltText      string    `json:"alt_text"`
+	Url          string    `json:"url"`
+	ThumbnailUrl string    `json:"thumbnail_url"`
+}
+
+func newMediaJSON(m database.Medium) MediaJSON {
+	return MediaJSON{
+		Id:           m.ID,
+		CreatedAt:    m.CreatedAt,
+		ContentType:  m.ContentType,
+		Width:        m.Width,
+		Height:       m.Height,
+		AltText:      m.AltText,
+		Url:          "/api/media/" + m.ID.String(),
+		ThumbnailUrl: "/api/media/" + m.ID.String() + "/thumbnail",
+	}
+}
+
+func (cfg *config) handlerUploadMedia(rw http.ResponseWriter, req *http.Request) {
+	userId := requestPrincipal(req).UserID
+
+	// Leave some room for the multipart framing and the alt text field.
+	req.Body = http.MaxBytesReader(rw, req.Body, cfg.MediaMaxBytes+64<<10)
+
+	reader, err := req.MultipartReader()
+
+	if err != nil {
+		respondWithError(rw, 400, "Expected a multipart/form-data body")
+		return
+	}
+
+	var data []byte
+	altText := ""
+
+	for {
+		part, err := reader.NextPart()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			respondWithUploadError(rw, err)
+			return
+		}
+
+		switch part.FormName() {
+		case "file":
+			data, err = io.ReadAll(io.LimitReader(part, cfg.MediaMaxBytes+1))
+		case "alt_text":
+			var text []byte
+			text, err = io.ReadAll(io.LimitReader(part, maxAltTextLength*utf8.UTFMax+1))
+			altText = string(text)
+		}
+
+		part.Close()
+
+		if err != nil {
+			respondWithUploadError(rw, err)
+			return
+		}
+	}
+
+	if data == nil {
+		respondWithError(rw, 400, "Missing file")
+		return
+	}
+
+	if int64(len(data)) > cfg.MediaMaxBytes {
+		respondWithError(rw, 413, "File is too large")
+		return
+	}
+
+	if !utf8.ValidString(altText) || utf8.RuneCountInString(altText) > maxAltTextLength {
+		respondWithError(rw, 400, "Alt text is too long")
+		return
+	}
+
+	img, err := media.Process(data)
+
+	if errors.Is(err, media.ErrUnsupportedType) {
+		respondWithError(rw, 415, err.Error())
+		return
+	}
+
+	if errors.Is(err, media.ErrTooManyPixels) || errors.Is(err, media.ErrTooManyFrames) {
+		respondWithError(rw, 413, err.Error())
+		return
+	}
+
+	if err != nil {
+		respondWithError(rw, 400, "Could not read image")
+		return
+	}
+
+	id := uuid.New()
+	storageKey := id.String() + "." + img.Extension
+	thumbnailKey := id.String() + "-thumb." + img.ThumbnailExtension
+
+	err = cfg.Media.Put(context.Background(), storageKey, img.Data, img.ContentType)
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	err = cfg.Media.Put(context.Background(), thumbnailKey, img.Thumbnail, media.ThumbnailType(img.ContentType))
+
+	if err != nil {
+		cfg.deleteMediaBlobs(database.Medium{StorageKey: storageKey})
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	row, err := cfg.Db.CreateMedia(context.Background(), database.CreateMediaParams{
+		ID:           id,
+		UserID:       userId,
+		ContentType:  img.ContentType,
+		SizeBytes:    int64(len(img.Data)),
+		Width:        int32(img.Width),
+		Height:       int32(img.Height),
+		AltText:      altText,
+		StorageKey:   storageKey,
+		ThumbnailKey: thumbnailKey,
+	})
+
+	if err != nil {
+		cfg.deleteMediaBlobs(database.Medium{StorageKey: storageKey, ThumbnailKey: thumbnailKey})
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	respondWithJSON(rw, 201, newMediaJSON(row))
+}
+
+func respondWithUploadError(rw http.ResponseWriter, err error) {
+	maxBytesErr := &http.MaxBytesError{}
+
+	if errors.As(err, &maxBytesErr) {
+		respondWithError(rw, 413, "File is too large")
+		return
+	}
+
+	respondWithError(rw, 400, "Could not read upload")
+}
+
+func (cfg *config) handlerGetMedia(rw http.ResponseWriter, req *http.Request) {
+	cfg.serveMedia(rw, req, false)
+}
+
+func (cfg *config) handlerGetMediaThumbnail(rw http.ResponseWriter, req *http.Request) {
+	cfg.serveMedia(rw, req, true)
+}
+
+func (cfg *config) serveMedia(rw http.ResponseWriter, req *http.Request, thumbnail bool) {
+	mediaUUID, err := uuid.Parse(req.PathValue("mediaId"))
+
+	if err != nil {
+		respondWithError(rw, 404, "Media not found")
+		return
+	}
+
+	row, err := cfg.Db.GetMediaById(context.Background(), mediaUUID)
+
+	if err != nil {
+		respondWithError(rw, 404, "Media not found")
+		return
+	}
+
+	key, contentType := row.StorageKey, row.ContentType
+
+	if thumbnail {
+		key, contentType = row.ThumbnailKey, media.ThumbnailType(row.ContentType)
+	}
+
+	blob, err := cfg.Media.Get(req.Context(), key)
+
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(rw, 404, "Media not found")
+		return
+	}
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	defer blob.Close()
+
+	rw.Header().Set("Content-Type", contentType)
+	rw.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(200)
+	io.Copy(rw, blob)
+}
+
+// attachMedia links uploads owned by the chirp author to a new chirp, in the
+// order given. It fails with errInvalidMedia if any id is unknown, belongs to
+// someone else or is already attached elsewhere.
+func attachMedia(q *database.Queries, chirp database.Chirp, ids []uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	attached, err := q.AttachMediaToChirp(context.Background(), database.AttachMediaToChirpParams{
+		ChirpID: chirp.ID,
+		Ids:     ids,
+		UserID:  chirp.UserID,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if attached != int64(len(ids)) {
+		return errInvalidMedia
+	}
+
+	return nil
+}
+
+// deleteMediaBlobs removes stored files once their rows are gone. Failures
+// only leave orphaned blobs behind, so they are logged rather than returned.
+func (cfg *config) deleteMediaBlobs(rows ...database.Medium) {
+	for _, row := range rows {
+		for _, key := range []string{row.StorageKey, row.ThumbnailKey} {
+			if key == "" {
+				continue
+			}
+
+			err := cfg.Media.Delete(context.Background(), key)
+
+			if err != nil {
+				log.Printf("media: could not delete %s: %v", key, err)
+			}
+		}
+	}
+}
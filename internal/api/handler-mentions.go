@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+// saveMentions resolves the @handles in a chirp body to users, stores their
+// positions and notifies anyone mentioned for the first time. Unknown handles
+// are left as plain text.
+func saveMentions(q *database.Queries, chirp database.Chirp, replace bool) error {
+	previous := []uuid.UUID{}
+
+	if replace {
+		removed, err := q.DeleteMentionsByChirpId(context.Background(), chirp.ID)
+		if err != nil {
+			return err
+		}
+
+		previous = removed
+	}
+
+	matches := extractMentions(chirp.Body)
+
+	if len(matches) == 0 {
+		return nil
+	}
+
+	handles := []string{}
+
+	for _, match := range matches {
+		if !slices.Contains(handles, match.Handle) {
+			handles = append(handles, match.Handle)
+		}
+	}
+
+	users, err := q.GetUsersByHandles(context.Background(), handles)
+	if err != nil {
+		return err
+	}
+
+	userIds := make(map[string]uuid.UUID, len(users))
+
+	for _, user := range users {
+		userIds[user.Handle.String] = user.ID
+	}
+
+	params := database.CreateMentionsParams{ChirpID: chirp.ID}
+	notified := []uuid.UUID{}
+
+	for _, match := range matches {
+		userId, ok := userIds[match.Handle]
+		if !ok {
+			continue
+		}
+
+		params.UserIds = append(params.UserIds, userId)
+		params.StartOffsets = append(params.StartOffsets, int32(match.Start))
+		params.EndOffsets = append(params.EndOffsets, int32(match.End))
+
+		if !slices.Contains(previous, userId) && !slices.Contains(notified, userId) {
+			notified = append(notified, userId)
+		}
+	}
+
+	if len(params.UserIds) == 0 {
+		return nil
+	}
+
+	err = q.CreateMentions(context.Background(), params)
+	if err != nil {
+		return err
+	}
+
+	return notify(q, notificationMention, uuid.NullUUID{UUID: chirp.UserID, Valid: true}, uuid.NullUUID{UUID: chirp.ID, Valid: true}, notified...)
+}
@@ -0,0 +1,554 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/auth"
+	"github.com/noueii/go-http-server/internal/database"
+	"github.com/noueii/go-http-server/internal/qr"
+)
+
+const (
+	totpIssuer        = "Chirpy"
+	mfaChallengeTTL   = 5 * time.Minute
+	maxMFAAttempts    = 5
+	recoveryCodeCount = 10
+	qrModuleSize      = 6
+)
+
+const (
+	securityEventMFAEnabled       = "mfa_enabled"
+	securityEventMFADisabled      = "mfa_disabled"
+	securityEventRecoveryCodeUsed = "recovery_code_used"
+)
+
+// sealedSecretPrefix marks a TOTP secret stored encrypted. Base32 secrets
+// stored in the clear never contain a colon.
+const sealedSecretPrefix = "sealed:"
+
+var errMFANotEnabled = errors.New("Two-factor authentication is not enabled")
+
+// handlerEnrollTOTP starts two-factor enrollment with a new secret. It has
+// no effect until a code from the authenticator is confirmed, and can be
+// repeated until then.
+func (cfg *config) handlerEnrollTOTP(rw http.ResponseWriter, req *http.Request) {
+	userId := requestPrincipal(req).UserID
+
+	type parameters struct {
+		CurrentPassword string `json:"current_password"`
+	}
+
+	params := parameters{}
+
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+
+	if err != nil {
+		respondWithError(rw, 400, "Could not decode request body")
+		return
+	}
+
+	user, err := cfg.Db.GetUserById(context.Background(), userId)
+
+	if err != nil {
+		respondUnauthorized(rw, "invalid_token", "Invalid or expired token")
+		return
+	}
+
+	if cfg.Passwords.Verify(req.Context(), params.CurrentPassword, user.HashedPassword) != nil {
+		respondWithError(rw, 403, "current_password is missing or incorrect")
+		return
+	}
+
+	secret, err := auth.MakeTOTPSecret()
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	storedSecret, err := cfg.sealTOTPSecret(userId, secret)
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	_, err = cfg.Db.UpsertTOTPCredential(context.Background(), database.UpsertTOTPCredentialParams{
+		UserID: userId,
+		Secret: storedSecret,
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(rw, 409, "Two-factor authentication is already enabled")
+		return
+	}
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	uri := auth.TOTPURI(totpIssuer, user.Email, secret)
+
+	code, err := qr.Encode([]byte(uri))
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	image, err := code.PNG(qrModuleSize)
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	type jsonBody struct {
+		Secret     string `json:"secret"`
+		OtpauthUri string `json:"otpauth_uri"`
+		QrCode     string `json:"qr_code"`
+	}
+
+	respondWithJSON(rw, 201, jsonBody{
+		Secret:     secret,
+		OtpauthUri: uri,
+		QrCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(image),
+	})
+}
+
+// handlerConfirmTOTP turns two-factor authentication on once the user proves
+// their authenticator works, and hands out the recovery codes. They are only
+// ever shown here.
+func (cfg *config) handlerConfirmTOTP(rw http.ResponseWriter, req *http.Request) {
+	userId := requestPrincipal(req).UserID
+
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	params := parameters{}
+
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+
+	if err != nil {
+		respondWithError(rw, 400, "Could not decode request body")
+		return
+	}
+
+	credential, err := cfg.Db.GetTOTPCredential(context.Background(), userId)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(rw, 404, "Two-factor enrollment has not been started")
+		return
+	}
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	if credential.ConfirmedAt.Valid {
+		respondWithError(rw, 409, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := cfg.openTOTPSecret(credential)
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(secret, params.Code, time.Now())
+
+	if !ok {
+		respondWithError(rw, 400, "Invalid code")
+		return
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for range recoveryCodeCount {
+		code, err := auth.MakeRecoveryCode()
+
+		if err != nil {
+			respondWithError(rw, 500, err.Error())
+			return
+		}
+
+		codes = append(codes, code)
+		hashes = append(hashes, auth.HashRecoveryCode(code))
+	}
+
+	err = cfg.withTx(func(q *database.Queries) error {
+		confirmed, err := q.ConfirmTOTPCredential(context.Background(), database.ConfirmTOTPCredentialParams{
+			UserID:       userId,
+			LastUsedStep: step,
+		})
+		if err != nil {
+			return err
+		}
+
+		if confirmed == 0 {
+			return sql.ErrNoRows
+		}
+
+		err = q.DeleteRecoveryCodes(context.Background(), userId)
+		if err != nil {
+			return err
+		}
+
+		return q.CreateRecoveryCodes(context.Background(), database.CreateRecoveryCodesParams{
+			UserID:     userId,
+			CodeHashes: hashes,
+		})
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(rw, 409, "Two-factor authentication is already enabled")
+		return
+	}
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	cfg.recordSecurityEvent(req, userId, securityEventMFAEnabled, uuid.NullUUID{})
+
+	type jsonBody struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	respondWithJSON(rw, 200, jsonBody{RecoveryCodes: codes})
+}
+
+// handlerDisableTOTP turns two-factor authentication off. It needs both the
+// password and a current code or recovery code.
+func (cfg *config) handlerDisableTOTP(rw http.ResponseWriter, req *http.Request) {
+	userId := requestPrincipal(req).UserID
+
+	type parameters struct {
+		CurrentPassword string `json:"current_password"`
+		Code            string `json:"code"`
+	}
+
+	params := parameters{}
+
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+
+	if err != nil {
+		respondWithError(rw, 400, "Could not decode request body")
+		return
+	}
+
+	user, err := cfg.Db.GetUserById(context.Background(), userId)
+
+	if err != nil {
+		respondUnauthorized(rw, "invalid_token", "Invalid or expired token")
+		return
+	}
+
+	if cfg.Passwords.Verify(req.Context(), params.CurrentPassword, user.HashedPassword) != nil {
+		respondWithError(rw, 403, "current_password is missing or incorrect")
+		return
+	}
+
+	ok, err := cfg.checkSecondFactor(req, userId, params.Code)
+
+	if errors.Is(err, errMFANotEnabled) {
+		respondWithError(rw, 404, err.Error())
+		return
+	}
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	if !ok {
+		respondWithError(rw, 403, "Invalid code")
+		return
+	}
+
+	err = cfg.withTx(func(q *database.Queries) error {
+		err := q.DeleteTOTPCredential(context.Background(), userId)
+		if err != nil {
+			return err
+		}
+
+		err = q.DeleteRecoveryCodes(context.Background(), userId)
+		if err != nil {
+			return err
+		}
+
+		return q.DeleteMFAChallengesByUserId(context.Background(), userId)
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	cfg.recordSecurityEvent(req, userId, securityEventMFADisabled, uuid.NullUUID{})
+
+	rw.WriteHeader(204)
+}
+
+// respondWithMFAChallenge stands in for the login response when the user has
+// two-factor authentication on. The challenge token is only good for
+// POST /api/login/mfa, for a few minutes and a few attempts.
+func (cfg *config) respondWithMFAChallenge(rw http.ResponseWriter, user database.User, deviceName string) {
+	token, err := auth.MakeRefreshToken()
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	expiresAt := time.Now().UTC().Add(mfaChallengeTTL)
+
+	err = cfg.Db.CreateMFAChallenge(context.Background(), database.CreateMFAChallengeParams{
+		TokenHash:  auth.HashToken(token),
+		ExpiresAt:  expiresAt,
+		UserID:     user.ID,
+		DeviceName: truncate(deviceName, maxDeviceNameLength),
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	type jsonBody struct {
+		MfaRequired bool      `json:"mfa_required"`
+		MfaToken    string    `json:"mfa_token"`
+		ExpiresAt   time.Time `json:"expires_at"`
+	}
+
+	respondWithJSON(rw, 200, jsonBody{
+		MfaRequired: true,
+		MfaToken:    token,
+		ExpiresAt:   expiresAt,
+	})
+}
+
+// handlerLoginMFA completes a login that returned mfa_required with a code
+// from the authenticator or a recovery code.
+func (cfg *config) handlerLoginMFA(rw http.ResponseWriter, req *http.Request) {
+	type parameters struct {
+		MfaToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	params := parameters{}
+
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+
+	if err != nil {
+		respondWithError(rw, 400, "Could not decode request body")
+		return
+	}
+
+	tokenHash := auth.HashToken(params.MfaToken)
+
+	challenge, err := cfg.Db.AttemptMFAChallenge(context.Background(), database.AttemptMFAChallengeParams{
+		TokenHash: tokenHash,
+		Now:       time.Now().UTC(),
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(rw, 401, "Invalid or expired challenge")
+		return
+	}
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	if challenge.Attempts > maxMFAAttempts {
+		err = cfg.Db.DeleteMFAChallenge(context.Background(), tokenHash)
+
+		if err != nil {
+			respondWithError(rw, 500, err.Error())
+			return
+		}
+
+		respondWithError(rw, 401, "Invalid or expired challenge")
+		return
+	}
+
+	user, err := cfg.Db.GetUserById(context.Background(), challenge.UserID)
+
+	if err != nil {
+		respondWithError(rw, 401, "Invalid or expired challenge")
+		return
+	}
+
+	// Codes count against the same account throttle as passwords, since a
+	// fresh challenge only costs another correct password.
+	attempt, wait, locked, err := cfg.beginLoginAttempt(req, loginAccountKey(user.Email))
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	if wait > 0 {
+		respondLoginBlocked(rw, wait, locked)
+		return
+	}
+
+	ok, err := cfg.checkSecondFactor(req, challenge.UserID, params.Code)
+
+	if errors.Is(err, errMFANotEnabled) {
+		respondWithError(rw, 401, "Invalid or expired challenge")
+		return
+	}
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	if !ok {
+		cfg.loginFailed(req, attempt, uuid.NullUUID{UUID: user.ID, Valid: true})
+		respondWithError(rw, 401, "Invalid code")
+		return
+	}
+
+	cfg.loginSucceeded(attempt)
+
+	err = cfg.Db.DeleteMFAChallenge(context.Background(), tokenHash)
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	cfg.respondWithLogin(rw, req, user, challenge.DeviceName)
+}
+
+// checkSecondFactor accepts a six digit code from the authenticator, each
+// time step only once, or an unused recovery code, which it spends.
+func (cfg *config) checkSecondFactor(req *http.Request, userId uuid.UUID, code string) (bool, error) {
+	credential, err := cfg.Db.GetTOTPCredential(context.Background(), userId)
+
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !credential.ConfirmedAt.Valid) {
+		return false, errMFANotEnabled
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if isTOTPCode(code) {
+		secret, err := cfg.openTOTPSecret(credential)
+
+		if err != nil {
+			return false, err
+		}
+
+		step, ok := auth.ValidateTOTP(secret, code, time.Now())
+
+		if !ok {
+			return false, nil
+		}
+
+		used, err := cfg.Db.UseTOTPStep(context.Background(), database.UseTOTPStepParams{
+			UserID:       userId,
+			LastUsedStep: step,
+		})
+
+		return used == 1, err
+	}
+
+	used, err := cfg.Db.UseRecoveryCode(context.Background(), database.UseRecoveryCodeParams{
+		UserID:   userId,
+		CodeHash: auth.HashRecoveryCode(code),
+	})
+
+	if err != nil || used == 0 {
+		return false, err
+	}
+
+	cfg.recordSecurityEvent(req, userId, securityEventRecoveryCodeUsed, uuid.NullUUID{})
+
+	return true, nil
+}
+
+// sealTOTPSecret encrypts a secret for storage when ENCRYPTION_KEY is set.
+// It is bound to the user, so it cannot be copied to another account.
+func (cfg *config) sealTOTPSecret(userId uuid.UUID, secret string) (string, error) {
+	if cfg.Sealer == nil {
+		return secret, nil
+	}
+
+	sealed, err := cfg.Sealer.Seal([]byte(secret), totpSecretBinding(userId))
+	if err != nil {
+		return "", err
+	}
+
+	return sealedSecretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openTOTPSecret reverses sealTOTPSecret. Secrets stored before
+// ENCRYPTION_KEY was set are still read in the clear.
+func (cfg *config) openTOTPSecret(credential database.TotpCredential) (string, error) {
+	encoded, sealed := strings.CutPrefix(credential.Secret, sealedSecretPrefix)
+
+	if !sealed {
+		return credential.Secret, nil
+	}
+
+	if cfg.Sealer == nil {
+		return "", errors.New("TOTP secret is encrypted and ENCRYPTION_KEY is not set")
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := cfg.Sealer.Open(ciphertext, totpSecretBinding(credential.UserID))
+	if err != nil {
+		return "", err
+	}
+
+	return string(secret), nil
+}
+
+func totpSecretBinding(userId uuid.UUID) []byte {
+	return []byte("totp:" + userId.String())
+}
+
+func isTOTPCode(code string) bool {
+	code = strings.ReplaceAll(code, " ", "")
+
+	if len(code) != 6 {
+		return false
+	}
+
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
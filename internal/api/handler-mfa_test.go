@@ -0,0 +1,50 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/auth"
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+func TestTOTPSecretSealing(t *testing.T) {
+	sealer, err := auth.NewSealer([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config{Sealer: sealer}
+	userId := uuid.New()
+
+	stored, err := cfg.sealTOTPSecret(userId, "JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(stored, sealedSecretPrefix) || strings.Contains(stored, "JBSWY3DPEHPK3PXP") {
+		t.Fatalf("secret stored as %q", stored)
+	}
+
+	secret, err := cfg.openTOTPSecret(database.TotpCredential{UserID: userId, Secret: stored})
+	if err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("got %q, %v", secret, err)
+	}
+
+	_, err = cfg.openTOTPSecret(database.TotpCredential{UserID: uuid.New(), Secret: stored})
+	if err == nil {
+		t.Fatal("expected an error for a secret copied to another user")
+	}
+
+	// Secrets stored before the key was set are read as they are.
+	secret, err = cfg.openTOTPSecret(database.TotpCredential{UserID: userId, Secret: "JBSWY3DPEHPK3PXP"})
+	if err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("got %q, %v", secret, err)
+	}
+
+	_, err = (&config{}).openTOTPSecret(database.TotpCredential{UserID: userId, Secret: stored})
+	if err == nil {
+		t.Fatal("expected an error without an encryption key")
+	}
+}
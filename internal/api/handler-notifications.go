@@ -0,0 +1,168 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+const (
+	notificationMention   = "mention"
+	notificationReply     = "reply"
+	notificationLike      = "like"
+	notificationFollow    = "follow"
+	notificationChirpyRed = "chirpy_red"
+)
+
+type NotificationJSON struct {
+	Id        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Kind      string     `json:"kind"`
+	ActorId   *uuid.UUID `json:"actor_id"`
+	ChirpId   *uuid.UUID `json:"chirp_id"`
+	Read      bool       `json:"read"`
+}
+
+type notificationPageJSON struct {
+	Notifications []NotificationJSON `json:"notifications"`
+	UnreadCount   int64              `json:"unread_count"`
+	NextCursor    string             `json:"next_cursor,omitempty"`
+}
+
+// notify records a notification of the given kind for each recipient. The
+// actor never gets notified about their own activity, and a recipient keeps
+// at most one like notification per actor and chirp.
+func notify(q *database.Queries, kind string, actorId, chirpId uuid.NullUUID, userIds ...uuid.UUID) error {
+	if len(userIds) == 0 {
+		return nil
+	}
+
+	return q.CreateNotifications(context.Background(), database.CreateNotificationsParams{
+		ActorID: actorId,
+		Kind:    kind,
+		ChirpID: chirpId,
+		UserIds: userIds,
+	})
+}
+
+func (cfg *config) handlerGetNotifications(rw http.ResponseWriter, req *http.Request) {
+	userId := requestPrincipal(req).UserID
+
+	limit, err := parsePageSize(req.URL.Query().Get("limit"))
+
+	if err != nil {
+		respondWithError(rw, 400, err.Error())
+		return
+	}
+
+	cursor, err := decodeCursor(req.URL.Query().Get("cursor"))
+
+	if err != nil || (cursor != nil && cursor.Prev) {
+		respondWithError(rw, 400, "Invalid cursor")
+		return
+	}
+
+	unreadOnly := false
+
+	switch req.URL.Query().Get("unread") {
+	case "", "false":
+	case "true":
+		unreadOnly = true
+	default:
+		respondWithError(rw, 400, "Invalid unread")
+		return
+	}
+
+	rows, err := cfg.Db.GetNotificationsPage(context.Background(), database.GetNotificationsPageParams{
+		UserID:          userId,
+		UnreadOnly:      unreadOnly,
+		CursorCreatedAt: cursor.createdAt(),
+		CursorID:        cursor.id(),
+		PageSize:        limit + 1,
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	unread, err := cfg.Db.CountUnreadNotifications(context.Background(), userId)
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	rows, next, _ := buildPage(rows, limit, nil, func(row database.Notification) pageCursor {
+		return pageCursor{CreatedAt: row.CreatedAt, Id: row.ID}
+	})
+
+	response := notificationPageJSON{
+		Notifications: make([]NotificationJSON, 0, len(rows)),
+		UnreadCount:   unread,
+		NextCursor:    next,
+	}
+
+	for _, row := range rows {
+		response.Notifications = append(response.Notifications, NotificationJSON{
+			Id:        row.ID,
+			CreatedAt: row.CreatedAt,
+			Kind:      row.Kind,
+			ActorId:   nullUUIDPtr(row.ActorID),
+			ChirpId:   nullUUIDPtr(row.ChirpID),
+			Read:      row.ReadAt.Valid,
+		})
+	}
+
+	respondWithJSON(rw, 200, response)
+}
+
+func (cfg *config) handlerMarkNotificationsRead(rw http.ResponseWriter, req *http.Request) {
+	userId := requestPrincipal(req).UserID
+
+	type parameters struct {
+		Ids []uuid.UUID `json:"ids"`
+		All bool        `json:"all"`
+	}
+
+	params := parameters{}
+
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+
+	if err != nil {
+		respondWithError(rw, 400, "Could not decode request body")
+		return
+	}
+
+	if !params.All && len(params.Ids) == 0 {
+		respondWithError(rw, 400, "Missing ids")
+		return
+	}
+
+	_, err = cfg.Db.MarkNotificationsRead(context.Background(), database.MarkNotificationsReadParams{
+		UserID: userId,
+		All:    params.All,
+		Ids:    params.Ids,
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	unread, err := cfg.Db.CountUnreadNotifications(context.Background(), userId)
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	respondWithJSON(rw, 200, struct {
+		UnreadCount int64 `json:"unread_count"`
+	}{unread})
+}
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/auth"
+	"github.com/noueii/go-http-server/internal/database"
+	"github.com/noueii/go-http-server/internal/oidc"
+)
+
+const (
+	oauthStateTTL    = 10 * time.Minute
+	oauthStateCookie = "chirpy_oauth_state"
+)
+
+const securityEventIdentityLinked = "identity_linked"
+
+var (
+	errIdentityEmailUnverified = errors.New("identity has no verified email")
+	errIdentityEmailConflict   = errors.New("email belongs to an unverified account")
+)
+
+// handlerOIDCLogin starts signing in with an external provider. It remembers
+// the PKCE verifier and nonce under a random state, which is also set in a
+// cookie so that only this browser can finish the sign-in, and redirects to
+// the provider.
+func (cfg *config) handlerOIDCLogin(rw http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("provider")
+	provider, ok := cfg.Providers[name]
+
+	if !ok {
+		respondWithError(rw, 404, "Unknown provider")
+		return
+	}
+
+	state, err := auth.MakeRefreshToken()
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	nonce, err := auth.MakeRefreshToken()
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	verifier, err := oidc.NewVerifier()
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(req.Context(), state, nonce, verifier)
+
+	if err != nil {
+		respondWithError(rw, 502, "Provider is unavailable")
+		return
+	}
+
+	err = cfg.Db.DeleteExpiredOAuthStates(context.Background(), time.Now().UTC())
+
+	if err != nil {
+		log.Printf("Could not delete expired OAuth states: %v", err)
+	}
+
+	expiresAt := time.Now().UTC().Add(oauthStateTTL)
+
+	err = cfg.Db.CreateOAuthState(context.Background(), database.CreateOAuthStateParams{
+		StateHash:    auth.HashToken(state),
+		ExpiresAt:    expiresAt,
+		Provider:     name,
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		DeviceName:   truncate(req.URL.Query().Get("device_name"), maxDeviceNameLength),
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	http.SetCookie(rw, cfg.oauthStateCookie(name, state, expiresAt))
+	http.Redirect(rw, req, authURL, 302)
+}
+
+// handlerOIDCCallback is where the provider sends the user back. The code is
+// redeemed for an ID token, the identity is resolved to a user and the
+// response is the same as for a password login.
+func (cfg *config) handlerOIDCCallback(rw http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("provider")
+	provider, ok := cfg.Providers[name]
+
+	if !ok {
+		respondWithError(rw, 404, "Unknown provider")
+		return
+	}
+
+	query := req.URL.Query()
+	state := query.Get("state")
+
+	cookie, err := req.Cookie(oauthStateCookie)
+
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		respondWithError(rw, 400, "Invalid or expired state")
+		return
+	}
+
+	http.SetCookie(rw, cfg.oauthStateCookie(name, "", time.Unix(0, 0)))
+
+	pending, err := cfg.Db.ConsumeOAuthState(context.Background(), database.ConsumeOAuthStateParams{
+		StateHash: auth.HashToken(state),
+		Provider:  name,
+		Now:       time.Now().UTC(),
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(rw, 400, "Invalid or expired state")
+		return
+	}
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	if query.Get("error") != "" {
+		respondWithError(rw, 401, "Sign-in was not completed: "+query.Get("error"))
+		return
+	}
+
+	identity, err := provider.Authenticate(req.Context(), query.Get("code"), pending.CodeVerifier, pending.Nonce)
+
+	tokenErr := &oidc.TokenError{}
+
+	if errors.As(err, &tokenErr) || errors.Is(err, oidc.ErrInvalidIDToken) {
+		log.Printf("Sign-in with %s failed: %v", name, err)
+		respondWithError(rw, 401, "Sign-in failed")
+		return
+	}
+
+	if err != nil {
+		log.Printf("Sign-in with %s failed: %v", name, err)
+		respondWithError(rw, 502, "Provider is unavailable")
+		return
+	}
+
+	user, err := cfg.userForIdentity(req, name, identity)
+
+	if errors.Is(err, errIdentityEmailUnverified) {
+		respondWithError(rw, 403, "Provider did not return a verified email")
+		return
+	}
+
+	if errors.Is(err, errIdentityEmailConflict) {
+		respondWithError(rw, 409, "An account with this email exists. Log in with your password and verify your email first")
+		return
+	}
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	cfg.completeLogin(rw, req, user, pending.DeviceName, nil)
+}
+
+// userForIdentity finds the account an external identity signs in to. An
+// identity seen before maps to its user. A new one is linked to the account
+// with the same email only when both the provider and Chirpy have verified
+// it, since otherwise whoever registered the email first would get in.
+// Without such an account a new, already verified one is created.
+func (cfg *config) userForIdentity(req *http.Request, provider string, identity *oidc.Identity) (database.User, error) {
+	user, err := cfg.Db.GetUserByIdentity(context.Background(), database.GetUserByIdentityParams{
+		Provider: provider,
+		Subject:  identity.Subject,
+	})
+
+	if err == nil {
+		err = cfg.Db.TouchUserIdentity(context.Background(), database.TouchUserIdentityParams{
+			Provider: provider,
+			Subject:  identity.Subject,
+			Email:    identity.Email,
+		})
+
+		return user, err
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return database.User{}, errIdentityEmailUnverified
+	}
+
+	user, err = cfg.Db.GetUserByEmail(context.Background(), identity.Email)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	linked := err == nil
+
+	if linked && !user.EmailVerifiedAt.Valid {
+		return database.User{}, errIdentityEmailConflict
+	}
+
+	// New accounts get a random password nobody knows. The owner can set a
+	// real one through the password reset flow.
+	var hashedPassword string
+
+	if !linked {
+		password, err := auth.MakeRefreshToken()
+		if err != nil {
+			return database.User{}, err
+		}
+
+		hashedPassword, err = cfg.Passwords.Hash(req.Context(), password)
+		if err != nil {
+			return database.User{}, err
+		}
+	}
+
+	err = cfg.withTx(func(q *database.Queries) error {
+		if !linked {
+			created, err := q.CreateUser(context.Background(), database.CreateUserParams{
+				Email:          identity.Email,
+				HashedPassword: hashedPassword,
+			})
+			if err != nil {
+				return err
+			}
+
+			user, err = q.MarkUserEmailVerified(context.Background(), database.MarkUserEmailVerifiedParams{
+				ID:    created.ID,
+				Email: created.Email,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return q.CreateUserIdentity(context.Background(), database.CreateUserIdentityParams{
+			Provider: provider,
+			Subject:  identity.Subject,
+			UserID:   user.ID,
+			Email:    identity.Email,
+		})
+	})
+
+	// Another first sign-in with the same identity may have got there first,
+	// in which case it is the account to sign in to.
+	if isUniqueViolation(err, "user_identities_pkey") || isUniqueViolation(err, "users_email_lower_key") {
+		user, err = cfg.Db.GetUserByIdentity(context.Background(), database.GetUserByIdentityParams{
+			Provider: provider,
+			Subject:  identity.Subject,
+		})
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return database.User{}, errIdentityEmailConflict
+		}
+
+		return user, err
+	}
+
+	if err != nil {
+		return database.User{}, err
+	}
+
+	if linked {
+		cfg.recordSecurityEvent(req, user.ID, securityEventIdentityLinked, uuid.NullUUID{})
+	}
+
+	return user, nil
+}
+
+// oauthStateCookie is scoped to the provider's routes and sent on the
+// top-level redirect back from the provider, which SameSite=Lax allows.
+func (cfg *config) oauthStateCookie(provider, state string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    state,
+		Path:     "/api/auth/" + provider,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
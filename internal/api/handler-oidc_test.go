@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/noueii/go-http-server/internal/oidc"
+)
+
+func TestLoadOIDCProviders(t *testing.T) {
+	t.Setenv("OIDC_PROVIDERS", "Google, gitlab")
+	t.Setenv("OIDC_GOOGLE_ISSUER", "https://accounts.google.com")
+	t.Setenv("OIDC_GOOGLE_CLIENT_ID", "google-client")
+	t.Setenv("OIDC_GOOGLE_CLIENT_SECRET", "google-secret")
+	t.Setenv("OIDC_GITLAB_ISSUER", "https://gitlab.com/")
+	t.Setenv("OIDC_GITLAB_CLIENT_ID", "gitlab-client")
+	t.Setenv("OIDC_GITLAB_SCOPES", "email,profile")
+
+	providers, err := loadOIDCProviders("https://chirpy.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	google := providers["google"]
+	if google == nil || google.Config.ClientSecret != "google-secret" || google.Config.RedirectURL != "https://chirpy.example/api/auth/google/callback" {
+		t.Fatalf("unexpected google provider %+v", google)
+	}
+
+	gitlab := providers["gitlab"]
+	if gitlab == nil || gitlab.Config.Issuer != "https://gitlab.com" || !slices.Equal(gitlab.Config.Scopes, []string{"openid", "email", "profile"}) {
+		t.Fatalf("unexpected gitlab provider %+v", gitlab)
+	}
+
+	t.Setenv("OIDC_PROVIDERS", "okta")
+
+	_, err = loadOIDCProviders("https://chirpy.example")
+	if err == nil {
+		t.Fatal("expected an error for a provider without an issuer")
+	}
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	cfg := &config{Providers: map[string]*oidc.Provider{
+		"mock": oidc.NewProvider(oidc.Config{Name: "mock", Issuer: "http://127.0.0.1:0", ClientID: "client"}),
+	}}
+
+	tests := []struct {
+		name     string
+		provider string
+		query    string
+		cookie   string
+		code     int
+	}{
+		{"unknown provider", "other", "?state=abc&code=x", "abc", 404},
+		{"missing state", "mock", "?code=x", "abc", 400},
+		{"missing cookie", "mock", "?state=abc&code=x", "", 400},
+		{"cookie mismatch", "mock", "?state=abc&code=x", "abd", 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/auth/"+tt.provider+"/callback"+tt.query, nil)
+			req.SetPathValue("provider", tt.provider)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oauthStateCookie, Value: tt.cookie})
+			}
+			rw := httptest.NewRecorder()
+
+			cfg.handlerOIDCCallback(rw, req)
+
+			if rw.Code != tt.code {
+				t.Fatalf("got status %d, want %d", rw.Code, tt.code)
+			}
+		})
+	}
+}
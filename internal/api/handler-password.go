@@ -0,0 +1,242 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/noueii/go-http-server/internal/auth"
+	"github.com/noueii/go-http-server/internal/database"
+	"github.com/noueii/go-http-server/internal/mailer"
+)
+
+const (
+	passwordResetTTL            = time.Hour
+	passwordResetResendInterval = time.Minute
+	// passwordResetWorkers bounds the lookups running in the background.
+	passwordResetWorkers = 8
+)
+
+const (
+	throttleScopeResetEmail = "reset_email"
+	throttleScopeResetIP    = "reset_ip"
+)
+
+// Reset requests are counted whether or not the address has an account, so
+// the throttle says nothing about which ones do.
+var (
+	resetEmailThrottle = throttlePolicy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       24 * time.Hour,
+	}
+	resetIPThrottle = throttlePolicy{
+		FreeAttempts: 10,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       time.Hour,
+	}
+)
+
+// handlerForgotPassword answers 202 straight away and does the lookup and
+// mailing in the background, so neither the status nor the response time
+// tells the caller whether the address has an account. Requests are
+// throttled per address and per client, and dropped when too many lookups
+// are already running.
+func (cfg *config) handlerForgotPassword(rw http.ResponseWriter, req *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	params := parameters{}
+
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+
+	if err != nil || params.Email == "" {
+		respondWithError(rw, 400, "Missing email")
+		return
+	}
+
+	_, wait, _, err := cfg.takeAttempt([]throttleTarget{
+		{throttleScopeResetEmail, loginAccountKey(params.Email), resetEmailThrottle},
+		{throttleScopeResetIP, clientIP(req), resetIPThrottle},
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	if wait > 0 {
+		rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respondWithError(rw, 429, "Too many password reset requests, please wait before trying again")
+		return
+	}
+
+	select {
+	case cfg.resetSlots <- struct{}{}:
+		go func() {
+			defer func() { <-cfg.resetSlots }()
+
+			err := cfg.sendPasswordReset(params.Email)
+
+			if err != nil {
+				log.Printf("password reset: %v", err)
+			}
+		}()
+	default:
+		log.Printf("password reset: too many in flight, dropping request")
+	}
+
+	rw.WriteHeader(202)
+}
+
+func (cfg *config) sendPasswordReset(email string) error {
+	user, err := cfg.Db.GetUserByEmail(context.Background(), email)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	latest, err := cfg.Db.GetLatestPasswordResetToken(context.Background(), user.ID)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if err == nil && time.Since(latest.ExpiresAt.Add(-passwordResetTTL)) < passwordResetResendInterval {
+		return nil
+	}
+
+	token, err := auth.MakeRefreshToken()
+
+	if err != nil {
+		return err
+	}
+
+	err = cfg.Db.CreatePasswordResetToken(context.Background(), database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().UTC().Add(passwordResetTTL),
+		UserID:    user.ID,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return cfg.Mailer.Send(context.Background(), mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body:    fmt.Sprintf("Someone asked to reset the password for this Chirpy account. Use this code within an hour to choose a new one:\n\n%s\n\nIf it was not you, you can ignore this email; your password has not changed.\n", token),
+	})
+}
+
+// handlerResetPassword sets a new password from a reset token. The token and
+// any others outstanding for the user are spent, and every session and access
+// token is revoked so other devices have to log in again.
+func (cfg *config) handlerResetPassword(rw http.ResponseWriter, req *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	params := parameters{}
+
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&params)
+
+	if err != nil {
+		respondWithError(rw, 400, "Could not decode request body")
+		return
+	}
+
+	if params.Token == "" {
+		respondWithError(rw, 400, "Invalid or expired token")
+		return
+	}
+
+	if params.Password == "" {
+		respondWithError(rw, 400, "Password cannot be empty")
+		return
+	}
+
+	hashedPassword, err := cfg.Passwords.Hash(req.Context(), params.Password)
+
+	if err != nil {
+		respondWithError(rw, 500, "Password could not be hashed")
+		return
+	}
+
+	err = cfg.withTx(func(q *database.Queries) error {
+		reset, err := q.ConsumePasswordResetToken(context.Background(), database.ConsumePasswordResetTokenParams{
+			TokenHash: auth.HashToken(params.Token),
+			Now:       time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+
+		err = q.UpdateUserPasswordById(context.Background(), database.UpdateUserPasswordByIdParams{
+			ID:             reset.UserID,
+			HashedPassword: hashedPassword,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = q.DeletePasswordResetTokensByUserId(context.Background(), reset.UserID)
+		if err != nil {
+			return err
+		}
+
+		return revokeAllTokens(q, reset.UserID)
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(rw, 400, "Invalid or expired token")
+		return
+	}
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	rw.WriteHeader(204)
+}
+
+// rehashPassword upgrades a hash made with an older algorithm or weaker
+// parameters while the plaintext is at hand. It only replaces the hash it
+// checked, so a concurrent password change wins, and failures just wait for
+// the next login.
+func (cfg *config) rehashPassword(ctx context.Context, user database.User, password string) {
+	hashedPassword, err := cfg.Passwords.Hash(ctx, password)
+
+	if err != nil {
+		log.Printf("Could not rehash password for user %s: %v", user.ID, err)
+		return
+	}
+
+	_, err = cfg.Db.RehashUserPassword(context.Background(), database.RehashUserPasswordParams{
+		NewHash: hashedPassword,
+		ID:      user.ID,
+		OldHash: user.HashedPassword,
+	})
+
+	if err != nil {
+		log.Printf("Could not rehash password for user %s: %v", user.ID, err)
+	}
+}
@@ -0,0 +1,381 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	maxLocationLength    = 30
+	maxWebsiteLength     = 100
+)
+
+// userProfileJSON is what a user sees of their own account.
+type userProfileJSON struct {
+	PublicUserJSON
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func (cfg *config) handlerGetUser(rw http.ResponseWriter, req *http.Request) {
+	userUUID, err := uuid.Parse(req.PathValue("userId"))
+
+	if err != nil {
+		respondWithError(rw, 404, "User not found")
+		return
+	}
+
+	user, err := cfg.Db.GetUserById(context.Background(), userUUID)
+
+	if err != nil {
+		respondWithError(rw, 404, "User not found")
+		return
+	}
+
+	respondWithJSON(rw, 200, newPublicUserJSON(user))
+}
+
+func (cfg *config) handlerGetUserByHandle(rw http.ResponseWriter, req *http.Request) {
+	handle := strings.ToLower(strings.TrimPrefix(req.PathValue("handle"), "@"))
+
+	user, err := cfg.Db.GetUserByHandle(context.Background(), sql.NullString{String: handle, Valid: true})
+
+	if err != nil {
+		respondWithError(rw, 404, "User not found")
+		return
+	}
+
+	respondWithJSON(rw, 200, newPublicUserJSON(user))
+}
+
+// handlerGetUserResource serves GET /api/users/{userId}/{resource}. The mux
+// cannot hold /api/users/by-handle/{handle} next to the per-user routes since
+// neither pattern is more specific, so both are dispatched from here.
+func (cfg *config) handlerGetUserResource(rw http.ResponseWriter, req *http.Request) {
+	if req.PathValue("userId") == "by-handle" {
+		req.SetPathValue("handle", req.PathValue("resource"))
+		cfg.handlerGetUserByHandle(rw, req)
+		return
+	}
+
+	switch req.PathValue("resource") {
+	case "likes":
+		cfg.handlerGetUserLikes(rw, req)
+	case "followers":
+		cfg.handlerGetFollowers(rw, req)
+	case "following":
+		cfg.handlerGetFollowing(rw, req)
+	default:
+		respondWithError(rw, 404, "Not found")
+	}
+}
+
+// handlerUpdateProfile applies a JSON Merge Patch (RFC 7386) to the caller's
+// account. Absent members are left alone and null clears a field. Changing
+// the email or password also needs current_password and revokes the caller's
+// tokens.
+func (cfg *config) handlerUpdateProfile(rw http.ResponseWriter, req *http.Request) {
+	contentType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+	if contentType != "" && contentType != "application/json" && contentType != "application/merge-patch+json" {
+		respondWithError(rw, 415, "Expected application/merge-patch+json")
+		return
+	}
+
+	patch := mergePatch{}
+
+	decoder := json.NewDecoder(req.Body)
+	err := decoder.Decode(&patch)
+
+	if err != nil || patch == nil {
+		respondWithError(rw, 400, "Request body must be a JSON object")
+		return
+	}
+
+	cfg.applyProfilePatch(rw, req, patch)
+}
+
+// applyProfilePatch updates the caller's account from a merge patch and
+// responds with the result.
+func (cfg *config) applyProfilePatch(rw http.ResponseWriter, req *http.Request, patch mergePatch) {
+	userId := requestPrincipal(req).UserID
+
+	for name := range patch {
+		if !slices.Contains(profilePatchFields, name) {
+			respondWithError(rw, 400, fmt.Sprintf("Unknown field %s", name))
+			return
+		}
+	}
+
+	user, err := cfg.Db.GetUserById(context.Background(), userId)
+
+	if err != nil {
+		respondWithError(rw, 401, "Unauthorized")
+		return
+	}
+
+	// Only the fields in the patch are written, so a password reset or rehash
+	// that lands after the read above is not overwritten with stale values.
+	update := database.UpdateUserByIdParams{ID: user.ID}
+
+	handle, present, err := patch.stringField("handle")
+
+	if err != nil {
+		respondWithError(rw, 400, err.Error())
+		return
+	}
+
+	if present {
+		update.SetHandle = true
+
+		if handle != nil && *handle != "" {
+			update.Handle = sql.NullString{String: strings.ToLower(strings.TrimPrefix(*handle, "@")), Valid: true}
+
+			if !isValidHandle(update.Handle.String) {
+				respondWithError(rw, 400, "Handle must be 3-30 letters, digits or underscores")
+				return
+			}
+		}
+	}
+
+	fields := []struct {
+		name   string
+		target *sql.NullString
+		max    int
+	}{
+		{"display_name", &update.DisplayName, maxDisplayNameLength},
+		{"bio", &update.Bio, maxBioLength},
+		{"location", &update.Location, maxLocationLength},
+		{"website", &update.Website, maxWebsiteLength},
+	}
+
+	for _, field := range fields {
+		value, present, err := patch.stringField(field.name)
+
+		if err != nil {
+			respondWithError(rw, 400, err.Error())
+			return
+		}
+
+		if !present {
+			continue
+		}
+
+		*field.target = sql.NullString{Valid: true}
+
+		if value == nil {
+			continue
+		}
+
+		trimmed := strings.TrimSpace(*value)
+
+		if utf8.RuneCountInString(trimmed) > field.max {
+			respondWithError(rw, 400, fmt.Sprintf("%s must be at most %d characters", field.name, field.max))
+			return
+		}
+
+		*field.target = sql.NullString{String: trimmed, Valid: true}
+	}
+
+	if update.Website.String != "" && update.Website.String != user.Website && !isValidWebsite(update.Website.String) {
+		respondWithError(rw, 400, "website must be an http or https URL")
+		return
+	}
+
+	avatarId, present, err := patch.stringField("avatar_media_id")
+
+	if err != nil {
+		respondWithError(rw, 400, err.Error())
+		return
+	}
+
+	if present {
+		update.SetAvatarMediaID = true
+
+		if avatarId != nil && *avatarId != "" {
+			mediaUUID, err := uuid.Parse(*avatarId)
+
+			if err != nil {
+				respondWithError(rw, 400, "Invalid avatar_media_id")
+				return
+			}
+
+			avatar, err := cfg.Db.GetMediaById(context.Background(), mediaUUID)
+
+			if err != nil || avatar.UserID != user.ID {
+				respondWithError(rw, 400, "Invalid avatar_media_id")
+				return
+			}
+
+			update.AvatarMediaID = uuid.NullUUID{UUID: avatar.ID, Valid: true}
+		}
+	}
+
+	email, emailPresent, err := patch.stringField("email")
+
+	if err != nil {
+		respondWithError(rw, 400, err.Error())
+		return
+	}
+
+	password, passwordPresent, err := patch.stringField("password")
+
+	if err != nil {
+		respondWithError(rw, 400, err.Error())
+		return
+	}
+
+	if emailPresent || passwordPresent {
+		currentPassword, _, err := patch.stringField("current_password")
+
+		if err != nil {
+			respondWithError(rw, 400, err.Error())
+			return
+		}
+
+		if currentPassword == nil || cfg.Passwords.Verify(req.Context(), *currentPassword, user.HashedPassword) != nil {
+			respondWithError(rw, 403, "current_password is missing or incorrect")
+			return
+		}
+	}
+
+	if emailPresent {
+		if email == nil || !isValidEmail(*email) {
+			respondWithError(rw, 400, "Invalid email")
+			return
+		}
+
+		update.Email = sql.NullString{String: *email, Valid: true}
+	}
+
+	if passwordPresent {
+		if password == nil || *password == "" {
+			respondWithError(rw, 400, "Password cannot be empty")
+			return
+		}
+
+		hashedPassword, err := cfg.Passwords.Hash(req.Context(), *password)
+
+		if err != nil {
+			respondWithError(rw, 500, "Password could not be hashed")
+			return
+		}
+
+		update.HashedPassword = sql.NullString{String: hashedPassword, Valid: true}
+	}
+
+	previousEmail := user.Email
+
+	// A new password logs every session out. A new email only retires the
+	// access tokens, which sessions pick up again on their next refresh.
+	err = cfg.withTx(func(q *database.Queries) error {
+		user, err = q.UpdateUserById(context.Background(), update)
+		if err != nil {
+			return err
+		}
+
+		if passwordPresent {
+			return revokeAllTokens(q, user.ID)
+		}
+
+		if user.Email != previousEmail {
+			_, err = q.BumpTokenVersion(context.Background(), user.ID)
+		}
+
+		return err
+	})
+
+	if isUniqueViolation(err, "users_handle_key") {
+		respondWithError(rw, 409, "Handle already taken")
+		return
+	}
+
+	if isUniqueViolation(err, "users_email_lower_key") {
+		respondWithError(rw, 409, "Email already in use")
+		return
+	}
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	if user.Email != previousEmail {
+		cfg.sendVerificationEmailOrLog(user)
+	}
+
+	respondWithJSON(rw, 200, userProfileJSON{
+		PublicUserJSON: newPublicUserJSON(user),
+		Email:          user.Email,
+		EmailVerified:  user.EmailVerifiedAt.Valid,
+		UpdatedAt:      user.UpdatedAt,
+	})
+}
+
+var profilePatchFields = []string{
+	"handle",
+	"display_name",
+	"bio",
+	"location",
+	"website",
+	"avatar_media_id",
+	"email",
+	"password",
+	"current_password",
+}
+
+// mergePatch is a JSON Merge Patch document, kept raw so that a member set to
+// null can be told apart from one that is missing.
+type mergePatch map[string]json.RawMessage
+
+// stringField reports whether name is in the patch and, if so, its value,
+// which is nil when the member is null.
+func (p mergePatch) stringField(name string) (*string, bool, error) {
+	raw, ok := p[name]
+	if !ok {
+		return nil, false, nil
+	}
+
+	if string(raw) == "null" {
+		return nil, true, nil
+	}
+
+	value := ""
+	err := json.Unmarshal(raw, &value)
+	if err != nil {
+		return nil, true, fmt.Errorf("%s must be a string or null", name)
+	}
+
+	return &value, true, nil
+}
+
+func isValidEmail(s string) bool {
+	address, err := mail.ParseAddress(s)
+
+	return err == nil && address.Address == s
+}
+
+func isValidWebsite(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMergePatchStringField(t *testing.T) {
+	patch := mergePatch{}
+	err := json.Unmarshal([]byte(`{"bio": "hi", "location": null, "website": 5}`), &patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, present, err := patch.stringField("bio")
+	if err != nil || !present || value == nil || *value != "hi" {
+		t.Fatalf("Expected bio to be set, got %v %v %v", value, present, err)
+	}
+
+	value, present, err = patch.stringField("location")
+	if err != nil || !present || value != nil {
+		t.Fatalf("Expected location to be cleared, got %v %v %v", value, present, err)
+	}
+
+	_, present, err = patch.stringField("display_name")
+	if err != nil || present {
+		t.Fatalf("Expected display_name to be absent, got %v %v", present, err)
+	}
+
+	_, _, err = patch.stringField("website")
+	if err == nil {
+		t.Fatal("Expected an error for a non-string website")
+	}
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+// searchResultJSON carries the chirp with the matched words wrapped in
+// <mark>. The rest of Highlight is HTML-escaped, so it can be rendered as is.
+type searchResultJSON struct {
+	ChirpJSON
+	Rank      float32 `json:"rank"`
+	Highlight string  `json:"highlight"`
+}
+
+type searchPageJSON struct {
+	Results    []searchResultJSON `json:"results"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
+func (cfg *config) handlerSearchChirps(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	tsQuery, err := buildTSQuery(query.Get("q"))
+
+	if err != nil {
+		respondWithError(rw, 400, err.Error())
+		return
+	}
+
+	limit, err := parsePageSize(query.Get("limit"))
+
+	if err != nil {
+		respondWithError(rw, 400, err.Error())
+		return
+	}
+
+	offset, err := decodeOffsetCursor(query.Get("cursor"))
+
+	if err != nil {
+		respondWithError(rw, 400, err.Error())
+		return
+	}
+
+	authorId := uuid.NullUUID{}
+
+	if query.Get("author_id") != "" {
+		authorUUID, err := uuid.Parse(query.Get("author_id"))
+
+		if err != nil {
+			respondWithError(rw, 400, "Invalid author id")
+			return
+		}
+
+		authorId = uuid.NullUUID{UUID: authorUUID, Valid: true}
+	}
+
+	since, err := parseTimeParam(query.Get("since"))
+
+	if err != nil {
+		respondWithError(rw, 400, "Invalid since")
+		return
+	}
+
+	until, err := parseTimeParam(query.Get("until"))
+
+	if err != nil {
+		respondWithError(rw, 400, "Invalid until")
+		return
+	}
+
+	rows, err := cfg.Db.SearchChirps(context.Background(), database.SearchChirpsParams{
+		Query:      tsQuery,
+		AuthorID:   authorId,
+		Since:      since,
+		Until:      until,
+		PageSize:   limit + 1,
+		PageOffset: offset,
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	response := searchPageJSON{
+		Results: make([]searchResultJSON, 0, len(rows)),
+	}
+
+	if len(rows) > int(limit) {
+		rows = rows[:limit]
+		response.NextCursor = encodeOffsetCursor(offset + limit)
+	}
+
+	chirps := make([]ChirpJSON, 0, len(rows))
+
+	for _, row := range rows {
+		chirps = append(chirps, newChirpJSON(database.Chirp{
+			ID:         row.ID,
+			CreatedAt:  row.CreatedAt,
+			UpdatedAt:  row.UpdatedAt,
+			Body:       row.Body,
+			UserID:     row.UserID,
+			Edited:     row.Edited,
+			ParentID:   row.ParentID,
+			RootID:     row.RootID,
+			ReplyCount: row.ReplyCount,
+			DeletedAt:  row.DeletedAt,
+			LikeCount:  row.LikeCount,
+		}))
+	}
+
+	err = cfg.enrichChirps(optionalUserId(req), chirps)
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	for i, row := range rows {
+		response.Results = append(response.Results, searchResultJSON{
+			ChirpJSON: chirps[i],
+			Rank:      row.Rank,
+			Highlight: row.Headline,
+		})
+	}
+
+	respondWithJSON(rw, 200, response)
+}
+
+// buildTSQuery turns user input into a to_tsquery expression. Bare words are
+// ANDed together, "quoted text" becomes a phrase and a trailing * makes a
+// prefix match. Anything that is not a letter or digit is dropped so users
+// cannot inject tsquery operators of their own.
+func buildTSQuery(input string) (string, error) {
+	terms := []string{}
+
+	for i, part := range strings.Split(input, `"`) {
+		if i%2 == 1 {
+			words := searchWords(part)
+			if len(words) == 1 {
+				terms = append(terms, words[0])
+			} else if len(words) > 1 {
+				terms = append(terms, "("+strings.Join(words, " <-> ")+")")
+			}
+			continue
+		}
+
+		for _, field := range strings.Fields(part) {
+			words := searchWords(field)
+			if len(words) == 0 {
+				continue
+			}
+
+			if strings.HasSuffix(field, "*") {
+				words[len(words)-1] += ":*"
+			}
+			terms = append(terms, words...)
+		}
+	}
+
+	if len(terms) == 0 {
+		return "", fmt.Errorf("Missing search query")
+	}
+
+	return strings.Join(terms, " & "), nil
+}
+
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func parseTimeParam(s string) (sql.NullTime, error) {
+	if s == "" {
+		return sql.NullTime{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, s)
+	}
+
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+
+	return sql.NullTime{Time: t.UTC(), Valid: true}, nil
+}
@@ -0,0 +1,31 @@
+package api
+
+import "testing"
+
+func TestBuildTSQuery(t *testing.T) {
+	cases := map[string]string{
+		"hello":                 "hello",
+		"hello world":           "hello & world",
+		`"hello world" go`:      "(hello <-> world) & go",
+		"chirp*":                "chirp:*",
+		"it's & | ! (fine)":     "it & s & fine",
+		`"unterminated phrase`:  "(unterminated <-> phrase)",
+		`"single" pre* "a b c"`: "single & pre:* & (a <-> b <-> c)",
+	}
+
+	for input, expected := range cases {
+		got, err := buildTSQuery(input)
+		if err != nil {
+			t.Fatalf("Failed to build query for %q: %v", input, err)
+		}
+		if got != expected {
+			t.Fatalf("Expected %q for %q, got %q", expected, input, got)
+		}
+	}
+
+	for _, input := range []string{"", "   ", `"" & |`} {
+		if _, err := buildTSQuery(input); err == nil {
+			t.Fatalf("Expected error for query %q, got nil", input)
+		}
+	}
+}
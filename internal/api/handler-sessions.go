@@ -0,0 +1,189 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/auth"
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+const (
+	maxDeviceNameLength = 100
+	maxUserAgentLength  = 512
+)
+
+const (
+	securityEventRefreshTokenReuse   = "refresh_token_reuse"
+	securityEventLoggedOutEverywhere = "logged_out_everywhere"
+)
+
+type SessionJSON struct {
+	Id         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IpAddress  string    `json:"ip_address"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// startSession records a new device session for the user and issues its
+// first refresh token.
+func (cfg *config) startSession(req *http.Request, userId uuid.UUID, deviceName string) (database.Session, string, error) {
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return database.Session{}, "", err
+	}
+
+	var session database.Session
+
+	err = cfg.withTx(func(q *database.Queries) error {
+		session, err = q.CreateSession(context.Background(), database.CreateSessionParams{
+			UserID:     userId,
+			DeviceName: truncate(deviceName, maxDeviceNameLength),
+			UserAgent:  truncate(req.UserAgent(), maxUserAgentLength),
+			IpAddress:  clientIP(req),
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = q.CreateRefreshToken(context.Background(), database.CreateRefreshTokenParams{
+			Token:     refreshToken,
+			UserID:    userId,
+			SessionID: session.ID,
+			ExpiresAt: time.Now().UTC().Add(cfg.RefreshTokenTTL),
+		})
+		return err
+	})
+
+	return session, refreshToken, err
+}
+
+// revokeAllTokens ends every session of the user and bumps their token
+// version, which retires the access tokens already handed out as well.
+func revokeAllTokens(q *database.Queries, userId uuid.UUID) error {
+	err := q.RevokeRefreshTokensByUserId(context.Background(), userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.BumpTokenVersion(context.Background(), userId)
+	return err
+}
+
+// handlerLogoutAll ends every session of the caller, this one included.
+func (cfg *config) handlerLogoutAll(rw http.ResponseWriter, req *http.Request) {
+	userId := requestPrincipal(req).UserID
+
+	err := cfg.withTx(func(q *database.Queries) error {
+		return revokeAllTokens(q, userId)
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	cfg.recordSecurityEvent(req, userId, securityEventLoggedOutEverywhere, uuid.NullUUID{})
+
+	rw.WriteHeader(204)
+}
+
+func (cfg *config) handlerGetSessions(rw http.ResponseWriter, req *http.Request) {
+	userId := requestPrincipal(req).UserID
+
+	sessions, err := cfg.Db.GetActiveSessionsByUserId(context.Background(), database.GetActiveSessionsByUserIdParams{
+		UserID: userId,
+		Now:    time.Now().UTC(),
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	response := make([]SessionJSON, 0, len(sessions))
+
+	for _, session := range sessions {
+		response = append(response, SessionJSON{
+			Id:         session.ID,
+			CreatedAt:  session.CreatedAt,
+			DeviceName: session.DeviceName,
+			UserAgent:  session.UserAgent,
+			IpAddress:  session.IpAddress,
+			LastUsedAt: session.LastUsedAt,
+		})
+	}
+
+	respondWithJSON(rw, 200, response)
+}
+
+func (cfg *config) handlerRevokeSession(rw http.ResponseWriter, req *http.Request) {
+	userId := requestPrincipal(req).UserID
+
+	sessionUUID, err := uuid.Parse(req.PathValue("sessionId"))
+
+	if err != nil {
+		respondWithError(rw, 404, "Session not found")
+		return
+	}
+
+	// Access tokens are not tied to a session and are left to expire; only
+	// logging out everywhere, credential changes and suspension retire them.
+	revoked, err := cfg.Db.RevokeSession(context.Background(), database.RevokeSessionParams{
+		ID:     sessionUUID,
+		UserID: userId,
+	})
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	if revoked == 0 {
+		respondWithError(rw, 404, "Session not found")
+		return
+	}
+
+	rw.WriteHeader(204)
+}
+
+// recordSecurityEvent stores an audit entry for the account. Failing to
+// store one should not fail the request it records, so errors are only
+// logged.
+func (cfg *config) recordSecurityEvent(req *http.Request, userId uuid.UUID, kind string, sessionId uuid.NullUUID) {
+	err := cfg.Db.CreateSecurityEvent(context.Background(), database.CreateSecurityEventParams{
+		UserID:    userId,
+		Kind:      kind,
+		SessionID: sessionId,
+		IpAddress: clientIP(req),
+		UserAgent: truncate(req.UserAgent(), maxUserAgentLength),
+	})
+
+	if err != nil {
+		log.Printf("security event %s for %s: %v", kind, userId, err)
+	}
+}
+
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+
+	return string(runes[:max])
+}
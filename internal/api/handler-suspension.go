@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+const (
+	securityEventAccountSuspended   = "account_suspended"
+	securityEventAccountUnsuspended = "account_unsuspended"
+)
+
+// handlerSuspendUser lets an admin suspend an account. Its sessions and access
+// tokens are revoked and it cannot log in until the suspension is lifted.
+func (cfg *config) handlerSuspendUser(rw http.ResponseWriter, req *http.Request) {
+	userUUID, err := uuid.Parse(req.PathValue("userId"))
+
+	if err != nil {
+		respondWithError(rw, 404, "User not found")
+		return
+	}
+
+	if userUUID == requestPrincipal(req).UserID {
+		respondWithError(rw, 400, "Cannot suspend your own account")
+		return
+	}
+
+	err = cfg.withTx(func(q *database.Queries) error {
+		_, err := q.SuspendUser(context.Background(), userUUID)
+		if err != nil {
+			return err
+		}
+
+		return q.RevokeRefreshTokensByUserId(context.Background(), userUUID)
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(rw, 404, "User not found")
+		return
+	}
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	cfg.recordSecurityEvent(req, userUUID, securityEventAccountSuspended, uuid.NullUUID{})
+
+	rw.WriteHeader(204)
+}
+
+func (cfg *config) handlerUnsuspendUser(rw http.ResponseWriter, req *http.Request) {
+	userUUID, err := uuid.Parse(req.PathValue("userId"))
+
+	if err != nil {
+		respondWithError(rw, 404, "User not found")
+		return
+	}
+
+	_, err = cfg.Db.UnsuspendUser(context.Background(), userUUID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(rw, 404, "User not found")
+		return
+	}
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	cfg.recordSecurityEvent(req, userUUID, securityEventAccountUnsuspended, uuid.NullUUID{})
+
+	rw.WriteHeader(204)
+}
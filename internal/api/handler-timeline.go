@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+func (cfg *config) handlerGetTimeline(rw http.ResponseWriter, req *http.Request) {
+	userId := requestPrincipal(req).UserID
+
+	limit, err := parsePageSize(req.URL.Query().Get("limit"))
+
+	if err != nil {
+		respondWithError(rw, 400, err.Error())
+		return
+	}
+
+	cursor, err := decodeCursor(req.URL.Query().Get("cursor"))
+
+	if err != nil {
+		respondWithError(rw, 400, err.Error())
+		return
+	}
+
+	var chirps []database.Chirp
+
+	if cursor != nil && cursor.Prev {
+		chirps, err = cfg.Db.GetTimelinePageNewer(context.Background(), database.GetTimelinePageNewerParams{
+			UserID:          userId,
+			CursorCreatedAt: cursor.createdAt(),
+			CursorID:        cursor.id(),
+			PageSize:        limit + 1,
+		})
+	} else {
+		chirps, err = cfg.Db.GetTimelinePageOlder(context.Background(), database.GetTimelinePageOlderParams{
+			UserID:          userId,
+			CursorCreatedAt: cursor.createdAt(),
+			CursorID:        cursor.id(),
+			PageSize:        limit + 1,
+		})
+	}
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	chirps, next, prev := buildPage(chirps, limit, cursor, func(chirp database.Chirp) pageCursor {
+		return pageCursor{CreatedAt: chirp.CreatedAt, Id: chirp.ID}
+	})
+
+	response := chirpPageJSON{
+		Chirps:     make([]ChirpJSON, 0, len(chirps)),
+		NextCursor: next,
+		PrevCursor: prev,
+	}
+
+	for _, chirp := range chirps {
+		response.Chirps = append(response.Chirps, newChirpJSON(chirp))
+	}
+
+	err = cfg.enrichChirps(uuid.NullUUID{UUID: userId, Valid: true}, response.Chirps)
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	respondWithJSON(rw, 200, response)
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/noueii/go-http-server/internal/auth"
+	"github.com/noueii/go-http-server/internal/database"
+	"github.com/noueii/go-http-server/internal/mailer"
+)
+
+const (
+	emailVerificationTTL       = 24 * time.Hour
+	verificationResendInterval = time.Minute
+)
+
+// Mail goes out through a queue so that a slow relay never holds up the
+// request that triggered it.
+const (
+	mailQueueSize = 256
+	mailWorkers   = 2
+	mailTimeout   = 30 * time.Second
+)
+
+// sendVerificationEmail replaces any outstanding verification tokens for the
+// user with a new one and mails a link for the user's current address.
+func (cfg *config) sendVerificationEmail(user database.User) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.withTx(func(q *database.Queries) error {
+		err := q.DeleteEmailVerificationTokensByUserId(context.Background(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		return q.CreateEmailVerificationToken(context.Background(), database.CreateEmailVerificationTokenParams{
+			TokenHash: auth.HashToken(token),
+			ExpiresAt: time.Now().UTC().Add(emailVerificationTTL),
+			UserID:    user.ID,
+			Email:     user.Email,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	link := cfg.BaseURL + "/api/users/verify?token=" + url.QueryEscape(token)
+
+	return cfg.Mailer.Send(context.Background(), mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body:    fmt.Sprintf("Confirm this address by opening the link below within 24 hours:\n\n%s\n\nIf you did not sign up for Chirpy you can ignore this email.\n", link),
+	})
+}
+
+// sendVerificationEmailOrLog is used after sign-up and email changes, where
+// a mail failure should not undo the change; the user can ask for a resend.
+// The message itself is delivered in the background by the mail queue.
+func (cfg *config) sendVerificationEmailOrLog(user database.User) {
+	err := cfg.sendVerificationEmail(user)
+
+	if err != nil {
+		log.Printf("verification: could not email %s: %v", user.ID, err)
+	}
+}
+
+func (cfg *config) handlerVerifyEmail(rw http.ResponseWriter, req *http.Request) {
+	token := req.URL.Query().Get("token")
+
+	if token == "" {
+		respondWithError(rw, 400, "Missing token")
+		return
+	}
+
+	var user database.User
+
+	err := cfg.withTx(func(q *database.Queries) error {
+		verification, err := q.ConsumeEmailVerificationToken(context.Background(), database.ConsumeEmailVerificationTokenParams{
+			TokenHash: auth.HashToken(token),
+			Now:       time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+
+		// The token only counts for the address it was sent to.
+		user, err = q.MarkUserEmailVerified(context.Background(), database.MarkUserEmailVerifiedParams{
+			ID:    verification.UserID,
+			Email: verification.Email,
+		})
+		return err
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(rw, 400, "Invalid or expired token")
+		return
+	}
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	respondWithJSON(rw, 200, struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}{user.Email, true})
+}
+
+func (cfg *config) handlerResendVerification(rw http.ResponseWriter, req *http.Request) {
+	userId := requestPrincipal(req).UserID
+
+	user, err := cfg.Db.GetUserById(context.Background(), userId)
+
+	if err != nil {
+		respondWithError(rw, 401, "Unauthorized")
+		return
+	}
+
+	if user.EmailVerifiedAt.Valid {
+		respondWithError(rw, 409, "Email already verified")
+		return
+	}
+
+	latest, err := cfg.Db.GetLatestEmailVerificationToken(context.Background(), user.ID)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	if err == nil {
+		sentAt := latest.ExpiresAt.Add(-emailVerificationTTL)
+		wait := time.Until(sentAt.Add(verificationResendInterval))
+
+		if wait > 0 {
+			rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			respondWithError(rw, 429, "Please wait before requesting another email")
+			return
+		}
+	}
+
+	err = cfg.sendVerificationEmail(user)
+
+	if err != nil {
+		respondWithError(rw, 500, err.Error())
+		return
+	}
+
+	rw.WriteHeader(204)
+}
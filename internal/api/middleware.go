@@ -0,0 +1,207 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/auth"
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+const authRealm = "chirpy"
+
+var (
+	errTokenRevoked = errors.New("token has been revoked")
+	errTokenState   = errors.New("could not load token state")
+)
+
+// Principal is the authenticated caller of a request, taken from the claims
+// of its access token.
+type Principal struct {
+	UserID      uuid.UUID
+	Role        string
+	IsChirpyRed bool
+	TokenID     string
+}
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+func principalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// requestPrincipal is the caller of a handler wrapped in RequireAuth.
+func requestPrincipal(req *http.Request) Principal {
+	principal, _ := principalFrom(req.Context())
+	return principal
+}
+
+// optionalUserId returns the caller's id on routes wrapped in OptionalAuth.
+// Endpoints that are public but personalise their output use it.
+func optionalUserId(req *http.Request) uuid.NullUUID {
+	principal, ok := principalFrom(req.Context())
+
+	if !ok {
+		return uuid.NullUUID{}
+	}
+
+	return uuid.NullUUID{UUID: principal.UserID, Valid: true}
+}
+
+// authenticate resolves the bearer token on the request. It reports false
+// when the request carries no token at all. Tokens issued before the user's
+// token version was last bumped, to a session that has since been revoked,
+// or to a suspended user, are revoked.
+func (cfg *config) authenticate(req *http.Request) (Principal, bool, error) {
+	if req.Header.Get("Authorization") == "" {
+		return Principal{}, false, nil
+	}
+
+	token, err := auth.GetBearerToken(req.Header)
+
+	if err != nil {
+		return Principal{}, true, err
+	}
+
+	claims, err := cfg.Tokens.ParseJWT(token)
+
+	if err != nil {
+		return Principal{}, true, err
+	}
+
+	userId, err := claims.UserID()
+
+	if err != nil {
+		return Principal{}, true, err
+	}
+
+	var sessionId uuid.NullUUID
+
+	if claims.SessionID != "" {
+		sessionId.UUID, err = uuid.Parse(claims.SessionID)
+
+		if err != nil {
+			return Principal{}, true, err
+		}
+
+		sessionId.Valid = true
+	}
+
+	state, err := cfg.Db.GetUserTokenState(context.Background(), database.GetUserTokenStateParams{
+		SessionID: sessionId,
+		ID:        userId,
+	})
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return Principal{}, true, errTokenRevoked
+	}
+
+	if err != nil {
+		return Principal{}, true, fmt.Errorf("%w: %v", errTokenState, err)
+	}
+
+	if state.SuspendedAt.Valid || state.SessionRevoked || state.TokenVersion != claims.TokenVersion {
+		return Principal{}, true, errTokenRevoked
+	}
+
+	return Principal{
+		UserID:      userId,
+		Role:        claims.Role,
+		IsChirpyRed: claims.IsChirpyRed,
+		TokenID:     claims.ID,
+	}, true, nil
+}
+
+// RequireAuth rejects requests without a valid access token and passes the
+// caller to next in the request context.
+func (cfg *config) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		principal, present, err := cfg.authenticate(req)
+
+		if !present {
+			respondUnauthorized(rw, "", "Unauthorized")
+			return
+		}
+
+		if err != nil {
+			respondInvalidToken(rw, err)
+			return
+		}
+
+		next(rw, req.WithContext(withPrincipal(req.Context(), principal)))
+	}
+}
+
+// OptionalAuth lets anonymous requests through but still rejects a token
+// that is present and invalid, so clients learn to refresh it.
+func (cfg *config) OptionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		principal, present, err := cfg.authenticate(req)
+
+		if !present {
+			next(rw, req)
+			return
+		}
+
+		if err != nil {
+			respondInvalidToken(rw, err)
+			return
+		}
+
+		next(rw, req.WithContext(withPrincipal(req.Context(), principal)))
+	}
+}
+
+// RequireRole is RequireAuth for callers whose token carries role.
+func (cfg *config) RequireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return cfg.RequireAuth(func(rw http.ResponseWriter, req *http.Request) {
+		if requestPrincipal(req).Role != role {
+			respondForbidden(rw, "insufficient_scope", "Forbidden")
+			return
+		}
+
+		next(rw, req)
+	})
+}
+
+// respondInvalidToken rejects a request whose token could not be accepted.
+// Failing to look up the token state is the server's fault, not the client's.
+func respondInvalidToken(rw http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errTokenRevoked):
+		respondUnauthorized(rw, "invalid_token", "Token has been revoked")
+	case errors.Is(err, errTokenState):
+		respondWithError(rw, 500, err.Error())
+	default:
+		respondUnauthorized(rw, "invalid_token", "Invalid or expired token")
+	}
+}
+
+// respondUnauthorized answers 401 with a Bearer challenge (RFC 6750). A
+// request without credentials gets the bare challenge and no error code.
+func respondUnauthorized(rw http.ResponseWriter, code, msg string) {
+	rw.Header().Set("WWW-Authenticate", bearerChallenge(code, msg))
+	respondWithError(rw, 401, msg)
+}
+
+func respondForbidden(rw http.ResponseWriter, code, msg string) {
+	rw.Header().Set("WWW-Authenticate", bearerChallenge(code, msg))
+	respondWithError(rw, 403, msg)
+}
+
+func bearerChallenge(code, msg string) string {
+	if code == "" {
+		return fmt.Sprintf("Bearer realm=%q", authRealm)
+	}
+
+	return fmt.Sprintf("Bearer realm=%q, error=%q, error_description=%q", authRealm, code, msg)
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/auth"
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+// tokenStateDriver answers every query with a single token_version,
+// suspended_at, session_revoked row, which is all authenticate reads from the
+// database. It is
+// also its own connector, so tests open it with sql.OpenDB instead of
+// registering a driver name, which can only be done once per process.
+type tokenStateDriver struct {
+	version        int64
+	suspended      bool
+	sessionRevoked bool
+}
+
+func (d *tokenStateDriver) Open(string) (driver.Conn, error) { return tokenStateConn{d}, nil }
+func (d *tokenStateDriver) Connect(context.Context) (driver.Conn, error) {
+	return tokenStateConn{d}, nil
+}
+func (d *tokenStateDriver) Driver() driver.Driver { return d }
+
+type tokenStateConn struct{ d *tokenStateDriver }
+
+func (c tokenStateConn) Prepare(string) (driver.Stmt, error) { return tokenStateStmt(c), nil }
+func (c tokenStateConn) Close() error                        { return nil }
+func (c tokenStateConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+type tokenStateStmt struct{ d *tokenStateDriver }
+
+func (s tokenStateStmt) Close() error  { return nil }
+func (s tokenStateStmt) NumInput() int { return -1 }
+func (s tokenStateStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, driver.ErrSkip
+}
+func (s tokenStateStmt) Query([]driver.Value) (driver.Rows, error) {
+	var suspendedAt driver.Value
+	if s.d.suspended {
+		suspendedAt = time.Now()
+	}
+
+	return &tokenStateRows{values: []driver.Value{s.d.version, suspendedAt, s.d.sessionRevoked}}, nil
+}
+
+type tokenStateRows struct {
+	values []driver.Value
+	done   bool
+}
+
+func (r *tokenStateRows) Close() error { return nil }
+func (r *tokenStateRows) Columns() []string {
+	return []string{"token_version", "suspended_at", "session_revoked"}
+}
+
+func (r *tokenStateRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+
+	r.done = true
+	copy(dest, r.values)
+	return nil
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	state := &tokenStateDriver{version: 2}
+	db := sql.OpenDB(state)
+	defer db.Close()
+
+	tokens := auth.SharedSecret{Secret: "test-secret", Config: auth.DefaultTokenConfig}
+	cfg := &config{Tokens: tokens, Db: database.New(db)}
+
+	userId := uuid.New()
+	token, err := tokens.MakeJWT(userId, auth.UserClaims{Role: auth.RoleUser, IsChirpyRed: true, TokenVersion: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := tokens.MakeJWT(userId, auth.UserClaims{Role: auth.RoleUser, IsChirpyRed: true, TokenVersion: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Principal
+	handler := func(rw http.ResponseWriter, req *http.Request) {
+		got, _ = principalFrom(req.Context())
+		rw.WriteHeader(204)
+	}
+
+	tests := []struct {
+		name      string
+		wrap      http.HandlerFunc
+		header    string
+		code      int
+		challenge string
+		suspended bool
+	}{
+		{"require missing", cfg.RequireAuth(handler), "", 401, `Bearer realm="chirpy"`, false},
+		{"require invalid", cfg.RequireAuth(handler), "Bearer nope", 401, `error="invalid_token"`, false},
+		{"require valid", cfg.RequireAuth(handler), "Bearer " + token, 204, "", false},
+		{"optional missing", cfg.OptionalAuth(handler), "", 204, "", false},
+		{"optional invalid", cfg.OptionalAuth(handler), "Bearer nope", 401, `error="invalid_token"`, false},
+		{"optional valid", cfg.OptionalAuth(handler), "Bearer " + token, 204, "", false},
+		{"role missing", cfg.RequireRole(auth.RoleAdmin, handler), "", 401, `Bearer realm="chirpy"`, false},
+		{"role forbidden", cfg.RequireRole(auth.RoleAdmin, handler), "Bearer " + token, 403, `error="insufficient_scope"`, false},
+		{"require stale version", cfg.RequireAuth(handler), "Bearer " + stale, 401, `error_description="Token has been revoked"`, false},
+		{"optional stale version", cfg.OptionalAuth(handler), "Bearer " + stale, 401, `error_description="Token has been revoked"`, false},
+		{"require suspended", cfg.RequireAuth(handler), "Bearer " + token, 401, `error_description="Token has been revoked"`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = Principal{}
+			state.suspended = tt.suspended
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rw := httptest.NewRecorder()
+
+			tt.wrap(rw, req)
+
+			if rw.Code != tt.code {
+				t.Fatalf("got status %d, want %d", rw.Code, tt.code)
+			}
+
+			challenge := rw.Header().Get("WWW-Authenticate")
+			if !strings.Contains(challenge, tt.challenge) || (tt.challenge == "") != (challenge == "") {
+				t.Fatalf("got WWW-Authenticate %q, want %q", challenge, tt.challenge)
+			}
+
+			if tt.code == 204 && tt.header != "" {
+				if got.UserID != userId || got.Role != auth.RoleUser || !got.IsChirpyRed || got.TokenID == "" {
+					t.Fatalf("unexpected principal %+v", got)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthRejectsRevokedSession(t *testing.T) {
+	state := &tokenStateDriver{version: 1}
+	db := sql.OpenDB(state)
+	defer db.Close()
+
+	tokens := auth.SharedSecret{Secret: "test-secret", Config: auth.DefaultTokenConfig}
+	cfg := &config{Tokens: tokens, Db: database.New(db)}
+
+	token, err := tokens.MakeJWT(uuid.New(), auth.UserClaims{TokenVersion: 1, SessionID: uuid.NewString()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := cfg.RequireAuth(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(204)
+	})
+
+	for _, revoked := range []bool{false, true} {
+		state.sessionRevoked = revoked
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rw := httptest.NewRecorder()
+
+		handler(rw, req)
+
+		want := 204
+		if revoked {
+			want = 401
+		}
+
+		if rw.Code != want {
+			t.Fatalf("session revoked %v: got status %d, want %d", revoked, rw.Code, want)
+		}
+	}
+}
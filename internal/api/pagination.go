@@ -0,0 +1,163 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// pageCursor is the position of a row in a (created_at, id) keyset. Clients
+// only ever see it as an opaque base64 string.
+type pageCursor struct {
+	CreatedAt time.Time `json:"t"`
+	Id        uuid.UUID `json:"i"`
+	Prev      bool      `json:"p,omitempty"`
+}
+
+func encodeCursor(c pageCursor) string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cursor")
+	}
+
+	c := pageCursor{}
+	err = json.Unmarshal(data, &c)
+	if err != nil || c.CreatedAt.IsZero() {
+		return nil, fmt.Errorf("Invalid cursor")
+	}
+
+	return &c, nil
+}
+
+// offsetCursor is used where results have no stable keyset, such as search
+// results ordered by rank.
+type offsetCursor struct {
+	Offset int32 `json:"o"`
+}
+
+func encodeOffsetCursor(offset int32) string {
+	data, err := json.Marshal(offsetCursor{Offset: offset})
+	if err != nil {
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeOffsetCursor(s string) (int32, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid cursor")
+	}
+
+	c := offsetCursor{}
+	err = json.Unmarshal(data, &c)
+	if err != nil || c.Offset < 0 {
+		return 0, fmt.Errorf("Invalid cursor")
+	}
+
+	return c.Offset, nil
+}
+
+func (c *pageCursor) createdAt() sql.NullTime {
+	if c == nil {
+		return sql.NullTime{}
+	}
+
+	return sql.NullTime{Time: c.CreatedAt, Valid: true}
+}
+
+func (c *pageCursor) id() uuid.NullUUID {
+	if c == nil {
+		return uuid.NullUUID{}
+	}
+
+	return uuid.NullUUID{UUID: c.Id, Valid: true}
+}
+
+func parsePageSize(s string) (int32, error) {
+	if s == "" {
+		return defaultPageSize, nil
+	}
+
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("Invalid limit")
+	}
+
+	return int32(min(limit, maxPageSize)), nil
+}
+
+// parseSort reports whether the requested order is newest first.
+func parseSort(s string) (bool, error) {
+	switch s {
+	case "", "asc":
+		return false, nil
+	case "desc":
+		return true, nil
+	}
+
+	return false, fmt.Errorf("Invalid sort")
+}
+
+// buildPage trims rows fetched with limit+1 down to a page and works out the
+// cursors on either side of it. Rows fetched for a prev cursor come back in
+// reverse and are flipped into display order here.
+func buildPage[T any](rows []T, limit int32, cursor *pageCursor, key func(T) pageCursor) ([]T, string, string) {
+	hasMore := len(rows) > int(limit)
+	if hasMore {
+		rows = rows[:limit]
+	}
+
+	backwards := cursor != nil && cursor.Prev
+	if backwards {
+		slices.Reverse(rows)
+	}
+
+	if len(rows) == 0 {
+		return rows, "", ""
+	}
+
+	next, prev := "", ""
+
+	if hasMore || backwards {
+		c := key(rows[len(rows)-1])
+		next = encodeCursor(c)
+	}
+
+	if cursor != nil && (hasMore || !backwards) {
+		c := key(rows[0])
+		c.Prev = true
+		prev = encodeCursor(c)
+	}
+
+	return rows, next, prev
+}
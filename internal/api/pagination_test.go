@@ -0,0 +1,90 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursor := pageCursor{
+		CreatedAt: time.Date(2025, 3, 1, 12, 30, 0, 123456000, time.UTC),
+		Id:        uuid.New(),
+		Prev:      true,
+	}
+
+	decoded, err := decodeCursor(encodeCursor(cursor))
+	if err != nil {
+		t.Fatalf("Failed to decode cursor: %v", err)
+	}
+	if *decoded != cursor {
+		t.Fatalf("Expected cursor %v, got %v", cursor, *decoded)
+	}
+}
+
+func TestDecodeInvalidCursor(t *testing.T) {
+	for _, s := range []string{"not base64!", "bm90IGpzb24", "e30"} {
+		if _, err := decodeCursor(s); err == nil {
+			t.Fatalf("Expected error for cursor %q, got nil", s)
+		}
+	}
+}
+
+func TestParsePageSize(t *testing.T) {
+	cases := map[string]int32{
+		"":    defaultPageSize,
+		"5":   5,
+		"500": maxPageSize,
+	}
+
+	for input, expected := range cases {
+		limit, err := parsePageSize(input)
+		if err != nil {
+			t.Fatalf("Failed to parse limit %q: %v", input, err)
+		}
+		if limit != expected {
+			t.Fatalf("Expected limit %d for %q, got %d", expected, input, limit)
+		}
+	}
+
+	for _, input := range []string{"0", "-1", "ten"} {
+		if _, err := parsePageSize(input); err == nil {
+			t.Fatalf("Expected error for limit %q, got nil", input)
+		}
+	}
+}
+
+func TestBuildPage(t *testing.T) {
+	base := time.Now().UTC()
+	key := func(i int) pageCursor {
+		return pageCursor{CreatedAt: base.Add(time.Duration(i) * time.Second)}
+	}
+
+	// First page with more rows available
+	rows, next, prev := buildPage([]int{1, 2, 3}, 2, nil, key)
+	if len(rows) != 2 || rows[1] != 2 {
+		t.Fatalf("Expected rows [1 2], got %v", rows)
+	}
+	if next == "" || prev != "" {
+		t.Fatalf("Expected only a next cursor, got next=%q prev=%q", next, prev)
+	}
+
+	// Last page reached by following a next cursor
+	forward := key(2)
+	rows, next, prev = buildPage([]int{3}, 2, &forward, key)
+	if len(rows) != 1 || next != "" || prev == "" {
+		t.Fatalf("Expected last page with only a prev cursor, got rows=%v next=%q prev=%q", rows, next, prev)
+	}
+
+	// Back to the first page through a prev cursor; rows arrive reversed
+	backward := key(3)
+	backward.Prev = true
+	rows, next, prev = buildPage([]int{2, 1}, 2, &backward, key)
+	if rows[0] != 1 || rows[1] != 2 {
+		t.Fatalf("Expected rows [1 2], got %v", rows)
+	}
+	if next == "" || prev != "" {
+		t.Fatalf("Expected only a next cursor, got next=%q prev=%q", next, prev)
+	}
+}
@@ -20,4 +20,8 @@ func New() (*App, error) {
 	}, nil
 }
 
+func (a *App) Close() {
+	a.Api.Close()
+}
+
 func CreateUser() {}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"net/http"
@@ -10,34 +11,126 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// HashPassword hashes with argon2id and the default parameters. Servers use
+// a PasswordHasher so the cost is configurable and bounded.
 func HashPassword(password string) (string, error) {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), 0)
+	salt := make([]byte, DefaultArgon2Params.SaltLength)
+	_, err := rand.Read(salt)
 	if err != nil {
 		return "", err
 	}
 
-	return string(hashed), nil
+	return encodeArgon2id(DefaultArgon2Params, salt, argon2idKey(password, salt, DefaultArgon2Params)), nil
 }
 
 func CheckPasswordHash(password, hash string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return verifyPassword(password, hash)
+}
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// TokenConfig describes the access tokens a Tokens implementation issues
+// and which ones it accepts.
+type TokenConfig struct {
+	Issuer string
+	// Audience is required on incoming tokens when set.
+	Audience string
+	TTL      time.Duration
+	// Leeway tolerates clock skew with other verifiers when checking exp,
+	// nbf and iat.
+	Leeway time.Duration
+}
+
+var DefaultTokenConfig = TokenConfig{
+	Issuer: "chirpy",
+	TTL:    time.Hour,
+}
+
+// UserClaims are the application claims carried next to the registered ones.
+// They are a snapshot taken at issue time and go stale until the next refresh.
+// TokenVersion is compared with the user's current version on every request,
+// so bumping it revokes every token issued before. SessionID names the
+// session the token was issued to, and revoking that session revokes it.
+type UserClaims struct {
+	Role         string `json:"role,omitempty"`
+	IsChirpyRed  bool   `json:"is_chirpy_red"`
+	TokenVersion int32  `json:"ver"`
+	SessionID    string `json:"sid,omitempty"`
+}
+
+type Claims struct {
+	jwt.RegisteredClaims
+	UserClaims
+}
+
+func (c *Claims) UserID() (uuid.UUID, error) {
+	return uuid.Parse(c.Subject)
+}
+
+func (c TokenConfig) newClaims(userID uuid.UUID, user UserClaims) Claims {
+	now := time.Now().UTC()
+
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    c.Issuer,
+			Subject:   userID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(c.TTL)),
+		},
+		UserClaims: user,
+	}
+
+	if c.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{c.Audience}
+	}
+
+	return claims
+}
+
+func (c TokenConfig) parserOptions(methods ...string) []jwt.ParserOption {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(c.Leeway),
+	}
+
+	if c.Issuer != "" {
+		options = append(options, jwt.WithIssuer(c.Issuer))
+	}
+
+	if c.Audience != "" {
+		options = append(options, jwt.WithAudience(c.Audience))
+	}
 
-	return err
+	return options
+}
+
+// Tokens issues and checks access tokens.
+type Tokens interface {
+	MakeJWT(userID uuid.UUID, user UserClaims) (string, error)
+	ParseJWT(tokenString string) (*Claims, error)
+	ValidateJWT(tokenString string) (uuid.UUID, error)
+	JWKS() JWKS
+}
 
+// SharedSecret signs tokens with HS256 and a secret every verifier must
+// know. It publishes no keys.
+type SharedSecret struct {
+	Secret string
+	Config TokenConfig
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * 1).UTC()),
-		Subject:   userID.String(),
-	})
+func (s SharedSecret) MakeJWT(userID uuid.UUID, user UserClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, s.Config.newClaims(userID, user))
 
-	signed, err := token.SignedString([]byte(tokenSecret))
+	signed, err := token.SignedString([]byte(s.Secret))
 
 	if err != nil {
 		return "", err
@@ -46,31 +139,44 @@ func MakeJWT(userID uuid.UUID, tokenSecret string) (string, error) {
 	return signed, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claims := &jwt.RegisteredClaims{}
+func (s SharedSecret) ParseJWT(tokenString string) (*Claims, error) {
+	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(tokenSecret), nil
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(s.Secret), nil
 
-	})
+	}, s.Config.parserOptions(jwt.SigningMethodHS256.Alg())...)
 
 	if err != nil {
-		return uuid.Nil, err
+		return nil, err
 	}
 
-	val, err := token.Claims.GetSubject()
+	return claims, nil
+}
+
+func (s SharedSecret) ValidateJWT(tokenString string) (uuid.UUID, error) {
+	claims, err := s.ParseJWT(tokenString)
 
 	if err != nil {
 		return uuid.Nil, err
 	}
 
-	res, err := uuid.Parse(val)
+	return claims.UserID()
+}
+
+func (s SharedSecret) JWKS() JWKS {
+	return JWKS{Keys: []JWK{}}
+}
 
-	if err != nil {
-		return uuid.Nil, err
-	}
+func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	config := DefaultTokenConfig
+	config.TTL = expiresIn
 
-	return res, nil
+	return SharedSecret{Secret: tokenSecret, Config: config}.MakeJWT(userID, UserClaims{})
+}
+
+func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	return SharedSecret{Secret: tokenSecret, Config: DefaultTokenConfig}.ValidateJWT(tokenString)
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -97,6 +203,14 @@ func MakeRefreshToken() (string, error) {
 
 }
 
+// HashToken returns the hex SHA-256 of a random token so that only the
+// digest has to be stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
 func GetApiKey(header http.Header) (string, error) {
 	str := header.Get("Authorization")
 
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"slices"
 	"testing"
 	"time"
 
@@ -191,3 +192,81 @@ func TestTokenIssuer(t *testing.T) {
 	// Additional verification could be done if you expose a way to check
 	// the token's claims in your API
 }
+
+func TestTokenClaims(t *testing.T) {
+	userID := uuid.New()
+	issuer := SharedSecret{
+		Secret: "test-secret",
+		Config: TokenConfig{Issuer: "chirpy", Audience: "chirpy-api", TTL: time.Hour},
+	}
+
+	token, err := issuer.MakeJWT(userID, UserClaims{Role: RoleAdmin, IsChirpyRed: true, TokenVersion: 3})
+	if err != nil {
+		t.Fatalf("Failed to create JWT: %v", err)
+	}
+
+	claims, err := issuer.ParseJWT(token)
+	if err != nil {
+		t.Fatalf("Failed to parse JWT: %v", err)
+	}
+	if claims.Role != RoleAdmin || !claims.IsChirpyRed || claims.TokenVersion != 3 {
+		t.Fatalf("Unexpected user claims %+v", claims.UserClaims)
+	}
+	if claims.ID == "" {
+		t.Fatal("Expected a jti claim")
+	}
+	if !slices.Contains(claims.Audience, "chirpy-api") {
+		t.Fatalf("Unexpected audience %v", claims.Audience)
+	}
+
+	other, err := issuer.MakeJWT(userID, UserClaims{})
+	if err != nil {
+		t.Fatalf("Failed to create JWT: %v", err)
+	}
+	otherClaims, err := issuer.ParseJWT(other)
+	if err != nil {
+		t.Fatalf("Failed to parse JWT: %v", err)
+	}
+	if otherClaims.ID == claims.ID {
+		t.Fatal("Expected a unique jti per token")
+	}
+
+	wrongAudience := issuer
+	wrongAudience.Config.Audience = "other-api"
+	_, err = wrongAudience.ValidateJWT(token)
+	if err == nil {
+		t.Fatal("Expected error for wrong audience, got nil")
+	}
+
+	// Tokens without an audience are rejected once one is configured.
+	plain, err := MakeJWT(userID, "test-secret", time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create JWT: %v", err)
+	}
+	_, err = issuer.ValidateJWT(plain)
+	if err == nil {
+		t.Fatal("Expected error for missing audience, got nil")
+	}
+}
+
+func TestTokenLeeway(t *testing.T) {
+	userID := uuid.New()
+
+	token, err := MakeJWT(userID, "test-secret", -10*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to create JWT: %v", err)
+	}
+
+	lenient := SharedSecret{Secret: "test-secret", Config: DefaultTokenConfig}
+	lenient.Config.Leeway = time.Minute
+
+	_, err = lenient.ValidateJWT(token)
+	if err != nil {
+		t.Fatalf("Expected token within leeway to validate: %v", err)
+	}
+
+	_, err = ValidateJWT(token, "test-secret")
+	if err == nil {
+		t.Fatal("Expected error for expired token without leeway, got nil")
+	}
+}
@@ -0,0 +1,620 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+// KeyStore is the subset of database.Queries the key ring needs, plus a way
+// to run several of them in one transaction.
+type KeyStore interface {
+	GetSigningKeys(ctx context.Context, retiresAt sql.NullTime) ([]database.SigningKey, error)
+	CreateSigningKey(ctx context.Context, arg database.CreateSigningKeyParams) (database.SigningKey, error)
+	RetireSigningKeys(ctx context.Context, arg database.RetireSigningKeysParams) error
+	DeleteRetiredSigningKeys(ctx context.Context, retiresAt sql.NullTime) error
+	LockSigningKeys(ctx context.Context) error
+	// InTx runs fn in a transaction, with a store bound to it, and commits
+	// if fn returns nil.
+	InTx(ctx context.Context, fn func(tx KeyStore) error) error
+}
+
+type signingKey struct {
+	kid       string
+	algorithm string
+	createdAt time.Time
+	retiresAt time.Time
+	private   crypto.Signer
+}
+
+// KeyRing signs tokens with the newest key for Algorithm and verifies them
+// with any key that has not yet retired. Keys live in the database so every
+// instance shares them. Once the newest key is older than RotateEvery a new
+// one is added and the others stay valid for Overlap, which must be longer
+// than Config.TTL.
+type KeyRing struct {
+	Algorithm   string
+	Config      TokenConfig
+	RotateEvery time.Duration
+	Overlap     time.Duration
+
+	db     KeyStore
+	sealer *Sealer
+	now    func() time.Time
+	// syncMu serializes reloads and rotations within this instance, and
+	// LockSigningKeys across instances; mu guards what they load.
+	syncMu   sync.Mutex
+	mu       sync.RWMutex
+	keys     []signingKey
+	lastSync time.Time
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+var signingMethods = map[string]jwt.SigningMethod{
+	"RS256": jwt.SigningMethodRS256,
+	"ES256": jwt.SigningMethodES256,
+	"EdDSA": jwt.SigningMethodEdDSA,
+}
+
+// minResyncInterval limits how often an unknown kid triggers a reload.
+const minResyncInterval = 10 * time.Second
+
+// encryptedKeyType is the PEM block type of a private key sealed with the
+// ring's encryption key.
+const encryptedKeyType = "CHIRPY ENCRYPTED PRIVATE KEY"
+
+// NewKeyRing loads the ring, creating its first key if there is none. With a
+// sealer new private keys are stored encrypted; without one they are stored
+// as plain PKCS #8 PEM, readable by anyone who can read the signing_keys
+// table. Plain keys are still read once a sealer is configured, and rotate
+// out as usual.
+func NewKeyRing(db KeyStore, algorithm string, config TokenConfig, rotateEvery, overlap time.Duration, sealer *Sealer) (*KeyRing, error) {
+	if _, ok := signingMethods[algorithm]; !ok {
+		return nil, fmt.Errorf("Unsupported signing algorithm %q", algorithm)
+	}
+
+	if overlap < config.TTL+config.Leeway {
+		return nil, fmt.Errorf("Key overlap %s is shorter than the token lifetime %s", overlap, config.TTL+config.Leeway)
+	}
+
+	ring := &KeyRing{
+		Algorithm:   algorithm,
+		Config:      config,
+		RotateEvery: rotateEvery,
+		Overlap:     overlap,
+		db:          db,
+		sealer:      sealer,
+		now:         time.Now,
+	}
+
+	err := ring.Sync(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return ring, nil
+}
+
+// Start checks for due rotations, and picks up keys other instances added,
+// every interval until Stop is called.
+func (r *KeyRing) Start(interval time.Duration) {
+	r.stop = make(chan struct{})
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				err := r.Sync(context.Background())
+				if err != nil {
+					log.Printf("keyring: sync failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+func (r *KeyRing) Stop() {
+	close(r.stop)
+	r.wg.Wait()
+}
+
+// Sync reloads the ring from the database, rotating first if the newest key
+// for Algorithm is missing or due. A rotation runs in one transaction holding
+// the signing key lock and checks again under it, so concurrent calls, from
+// this instance or any other, never add two keys for the same rotation.
+func (r *KeyRing) Sync(ctx context.Context) error {
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
+
+	now := r.now().UTC()
+
+	keys, err := r.load(ctx, r.db, now)
+	if err != nil {
+		return err
+	}
+
+	if r.due(keys, now) {
+		err = r.db.InTx(ctx, func(tx KeyStore) error {
+			err := tx.LockSigningKeys(ctx)
+			if err != nil {
+				return err
+			}
+
+			// Another instance may have rotated while we waited for the lock.
+			keys, err = r.load(ctx, tx, now)
+			if err != nil || !r.due(keys, now) {
+				return err
+			}
+
+			err = r.rotate(ctx, tx, now)
+			if err != nil {
+				return err
+			}
+
+			keys, err = r.load(ctx, tx, now)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	r.store(keys, now)
+
+	return nil
+}
+
+// due reports whether the newest key for Algorithm is missing or older than
+// RotateEvery.
+func (r *KeyRing) due(keys []signingKey, now time.Time) bool {
+	active := activeKey(keys, r.Algorithm)
+
+	return active == nil || (r.RotateEvery > 0 && now.Sub(active.createdAt) >= r.RotateEvery)
+}
+
+// reload picks up keys other instances added without ever rotating, for the
+// request path. Callers that queue up behind one reload skip their own.
+func (r *KeyRing) reload(ctx context.Context) error {
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
+
+	now := r.now().UTC()
+
+	r.mu.RLock()
+	fresh := now.Sub(r.lastSync) < minResyncInterval
+	r.mu.RUnlock()
+
+	if fresh {
+		return nil
+	}
+
+	keys, err := r.load(ctx, r.db, now)
+	if err != nil {
+		return err
+	}
+
+	r.store(keys, now)
+
+	return nil
+}
+
+func (r *KeyRing) store(keys []signingKey, now time.Time) {
+	r.mu.Lock()
+	r.keys = keys
+	r.lastSync = now
+	r.mu.Unlock()
+}
+
+func (r *KeyRing) load(ctx context.Context, db KeyStore, now time.Time) ([]signingKey, error) {
+	rows, err := db.GetSigningKeys(ctx, sql.NullTime{Time: now, Valid: true})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]signingKey, 0, len(rows))
+
+	for _, row := range rows {
+		der, err := r.open(row)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, fmt.Errorf("keyring: key %s: %w", row.Kid, err)
+		}
+
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("keyring: key %s cannot sign", row.Kid)
+		}
+
+		keys = append(keys, signingKey{
+			kid:       row.Kid,
+			algorithm: row.Algorithm,
+			createdAt: row.CreatedAt,
+			retiresAt: row.RetiresAt.Time,
+			private:   signer,
+		})
+	}
+
+	return keys, nil
+}
+
+// rotate adds a new key and retires the others. Callers run it in a
+// transaction so the three statements land together.
+func (r *KeyRing) rotate(ctx context.Context, db KeyStore, now time.Time) error {
+	private, err := generateKey(r.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+
+	random := make([]byte, 12)
+	_, err = rand.Read(random)
+	if err != nil {
+		return err
+	}
+
+	kid := base64.RawURLEncoding.EncodeToString(random)
+
+	sealed, err := r.seal(kid, der)
+	if err != nil {
+		return err
+	}
+
+	key, err := db.CreateSigningKey(ctx, database.CreateSigningKeyParams{
+		Kid:        kid,
+		CreatedAt:  now,
+		Algorithm:  r.Algorithm,
+		PrivateKey: sealed,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = db.RetireSigningKeys(ctx, database.RetireSigningKeysParams{
+		Kid:       key.Kid,
+		RetiresAt: sql.NullTime{Time: now.Add(r.Overlap), Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+
+	return db.DeleteRetiredSigningKeys(ctx, sql.NullTime{Time: now, Valid: true})
+}
+
+// seal PEM-encodes a private key for the database, encrypted when the ring
+// has an encryption key. The kid is authenticated along with it, so a sealed
+// key cannot be copied into another row.
+func (r *KeyRing) seal(kid string, der []byte) (string, error) {
+	if r.sealer == nil {
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+	}
+
+	sealed, err := r.sealer.Seal(der, []byte(kid))
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: encryptedKeyType, Bytes: sealed})), nil
+}
+
+// open reverses seal and returns the PKCS #8 DER of a stored key.
+func (r *KeyRing) open(row database.SigningKey) ([]byte, error) {
+	block, _ := pem.Decode([]byte(row.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("keyring: key %s is not PEM encoded", row.Kid)
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		return block.Bytes, nil
+
+	case encryptedKeyType:
+		if r.sealer == nil {
+			return nil, fmt.Errorf("keyring: key %s is encrypted and no encryption key is set", row.Kid)
+		}
+
+		der, err := r.sealer.Open(block.Bytes, []byte(row.Kid))
+		if err != nil {
+			return nil, fmt.Errorf("keyring: key %s could not be decrypted: %w", row.Kid, err)
+		}
+
+		return der, nil
+	}
+
+	return nil, fmt.Errorf("keyring: key %s has unexpected PEM type %q", row.Kid, block.Type)
+}
+
+func generateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case "RS256":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	}
+
+	return nil, fmt.Errorf("Unsupported signing algorithm %q", algorithm)
+}
+
+// activeKey is the newest unretired key for the algorithm.
+func activeKey(keys []signingKey, algorithm string) *signingKey {
+	for i := len(keys) - 1; i >= 0; i-- {
+		if keys[i].algorithm == algorithm && keys[i].retiresAt.IsZero() {
+			return &keys[i]
+		}
+	}
+
+	return nil
+}
+
+func (r *KeyRing) MakeJWT(userID uuid.UUID, user UserClaims) (string, error) {
+	r.mu.RLock()
+	key := activeKey(r.keys, r.Algorithm)
+	r.mu.RUnlock()
+
+	if key == nil {
+		return "", fmt.Errorf("keyring: no active signing key")
+	}
+
+	token := jwt.NewWithClaims(signingMethods[key.algorithm], r.Config.newClaims(userID, user))
+	token.Header["kid"] = key.kid
+
+	return token.SignedString(key.private)
+}
+
+// ParseJWT only accepts tokens whose kid names a live key and whose alg is
+// exactly that key's algorithm, so a token cannot pick its own algorithm.
+func (r *KeyRing) ParseJWT(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		key := r.lookup(kid)
+		if key == nil {
+			return nil, fmt.Errorf("Unknown signing key %q", kid)
+		}
+
+		if token.Method.Alg() != key.algorithm {
+			return nil, fmt.Errorf("Unexpected signing algorithm %s", token.Method.Alg())
+		}
+
+		return key.private.Public(), nil
+	}, r.Config.parserOptions(r.algorithms()...)...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (r *KeyRing) ValidateJWT(tokenString string) (uuid.UUID, error) {
+	claims, err := r.ParseJWT(tokenString)
+
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return claims.UserID()
+}
+
+func (r *KeyRing) lookup(kid string) *signingKey {
+	find := func() (*signingKey, time.Time) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+
+		now := r.now()
+
+		for i := range r.keys {
+			key := r.keys[i]
+			if key.kid == kid && (key.retiresAt.IsZero() || key.retiresAt.After(now)) {
+				return &key, r.lastSync
+			}
+		}
+
+		return nil, r.lastSync
+	}
+
+	key, lastSync := find()
+
+	// Another instance may have rotated since our last sync. Rotating is left
+	// to Sync, so a flood of unknown kids cannot add keys.
+	if key == nil && kid != "" && r.now().Sub(lastSync) >= minResyncInterval {
+		err := r.reload(context.Background())
+		if err != nil {
+			log.Printf("keyring: sync failed: %v", err)
+			return nil
+		}
+
+		key, _ = find()
+	}
+
+	return key
+}
+
+func (r *KeyRing) algorithms() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	algorithms := []string{r.Algorithm}
+
+	for _, key := range r.keys {
+		if !slices.Contains(algorithms, key.algorithm) {
+			algorithms = append(algorithms, key.algorithm)
+		}
+	}
+
+	return algorithms
+}
+
+// JWK is a public key in JSON Web Key form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicKey decodes the key for verifying signatures. It reverses what JWKS
+// publishes and also reads the P-384 and P-521 curves other issuers use.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding.DecodeString
+
+	switch k.Kty {
+	case "RSA":
+		n, err := b64(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := b64(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA key %q", k.Kid)
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		curves := map[string]struct {
+			curve elliptic.Curve
+			check ecdh.Curve
+		}{
+			"P-256": {elliptic.P256(), ecdh.P256()},
+			"P-384": {elliptic.P384(), ecdh.P384()},
+			"P-521": {elliptic.P521(), ecdh.P521()},
+		}
+
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := b64(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := b64(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		// NewPublicKey rejects points that are not on the curve.
+		_, err = curve.check.NewPublicKey(append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC key %q", k.Kid)
+		}
+
+		return &ecdsa.PublicKey{Curve: curve.curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		x, err := b64(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		if k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid OKP key %q", k.Kid)
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// JWKS lists the public half of every live key, including ones that are
+// retiring, so verifiers accept everything the ring still accepts.
+func (r *KeyRing) JWKS() JWKS {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	set := JWKS{Keys: []JWK{}}
+	now := r.now()
+
+	for _, key := range r.keys {
+		if !key.retiresAt.IsZero() && !key.retiresAt.After(now) {
+			continue
+		}
+
+		jwk := JWK{Use: "sig", Alg: key.algorithm, Kid: key.kid}
+		b64 := base64.RawURLEncoding.EncodeToString
+
+		switch public := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = b64(public.N.Bytes())
+			jwk.E = b64(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			point, err := public.ECDH()
+			if err != nil {
+				continue
+			}
+			raw := point.Bytes()
+			jwk.Kty = "EC"
+			jwk.Crv = "P-256"
+			jwk.X = b64(raw[1:33])
+			jwk.Y = b64(raw[33:])
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = b64(public)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+type memoryKeyStore struct {
+	keys []database.SigningKey
+	// beforeTx, if set, runs before the next transaction starts.
+	beforeTx func()
+}
+
+func (m *memoryKeyStore) GetSigningKeys(ctx context.Context, retiresAt sql.NullTime) ([]database.SigningKey, error) {
+	keys := []database.SigningKey{}
+	for _, key := range m.keys {
+		if !key.RetiresAt.Valid || key.RetiresAt.Time.After(retiresAt.Time) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (m *memoryKeyStore) CreateSigningKey(ctx context.Context, arg database.CreateSigningKeyParams) (database.SigningKey, error) {
+	key := database.SigningKey{
+		Kid:        arg.Kid,
+		CreatedAt:  arg.CreatedAt,
+		Algorithm:  arg.Algorithm,
+		PrivateKey: arg.PrivateKey,
+	}
+	m.keys = append(m.keys, key)
+	return key, nil
+}
+
+func (m *memoryKeyStore) RetireSigningKeys(ctx context.Context, arg database.RetireSigningKeysParams) error {
+	for i := range m.keys {
+		if m.keys[i].Kid != arg.Kid && !m.keys[i].RetiresAt.Valid {
+			m.keys[i].RetiresAt = arg.RetiresAt
+		}
+	}
+	return nil
+}
+
+func (m *memoryKeyStore) DeleteRetiredSigningKeys(ctx context.Context, retiresAt sql.NullTime) error {
+	keys := []database.SigningKey{}
+	for _, key := range m.keys {
+		if !key.RetiresAt.Valid || key.RetiresAt.Time.After(retiresAt.Time) {
+			keys = append(keys, key)
+		}
+	}
+	m.keys = keys
+	return nil
+}
+
+func (m *memoryKeyStore) LockSigningKeys(ctx context.Context) error {
+	return nil
+}
+
+func (m *memoryKeyStore) InTx(ctx context.Context, fn func(tx KeyStore) error) error {
+	if m.beforeTx != nil {
+		before := m.beforeTx
+		m.beforeTx = nil
+		before()
+	}
+	return fn(m)
+}
+
+func TestKeyRingAlgorithms(t *testing.T) {
+	for _, algorithm := range []string{"RS256", "ES256", "EdDSA"} {
+		t.Run(algorithm, func(t *testing.T) {
+			ring, err := NewKeyRing(&memoryKeyStore{}, algorithm, DefaultTokenConfig, time.Hour, 2*time.Hour, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			userID := uuid.New()
+
+			token, err := ring.MakeJWT(userID, UserClaims{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := ring.ValidateJWT(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != userID {
+				t.Fatalf("got %v, want %v", got, userID)
+			}
+
+			jwks := ring.JWKS()
+			if len(jwks.Keys) != 1 || jwks.Keys[0].Alg != algorithm || jwks.Keys[0].Use != "sig" {
+				t.Fatalf("unexpected JWKS %+v", jwks)
+			}
+
+			// A verifier holding only the published key accepts the token.
+			public, err := jwks.Keys[0].PublicKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return public, nil }, jwt.WithValidMethods([]string{algorithm}))
+			if err != nil {
+				t.Fatalf("published key does not verify the token: %v", err)
+			}
+		})
+	}
+}
+
+func TestKeyRingRejectsOtherAlgorithms(t *testing.T) {
+	ring, err := NewKeyRing(&memoryKeyStore{}, "ES256", DefaultTokenConfig, time.Hour, 2*time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kid := ring.JWKS().Keys[0].Kid
+	claims := jwt.RegisteredClaims{
+		Issuer:    "chirpy",
+		Subject:   uuid.New().String(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hs.Header["kid"] = kid
+	signed, err := hs.SignedString([]byte(kid))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ring.ValidateJWT(signed)
+	if err == nil {
+		t.Fatal("accepted an HS256 token")
+	}
+
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	none.Header["kid"] = kid
+	signed, err = none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ring.ValidateJWT(signed)
+	if err == nil {
+		t.Fatal("accepted an unsigned token")
+	}
+
+	_, err = ValidateJWT(signed, "")
+	if err == nil {
+		t.Fatal("shared secret accepted an unsigned token")
+	}
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	now := time.Now()
+	store := &memoryKeyStore{}
+
+	ring, err := NewKeyRing(store, "EdDSA", DefaultTokenConfig, 24*time.Hour, 2*time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring.now = func() time.Time { return now }
+
+	old, err := ring.MakeJWT(uuid.New(), UserClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKid := ring.JWKS().Keys[0].Kid
+
+	now = now.Add(25 * time.Hour)
+
+	err = ring.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ring.JWKS().Keys) != 2 {
+		t.Fatalf("expected old and new key during overlap, got %+v", ring.JWKS())
+	}
+
+	current, err := ring.MakeJWT(uuid.New(), UserClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(current, oldKid) {
+		t.Fatal("new tokens still use the old key")
+	}
+
+	_, err = ring.ValidateJWT(old)
+	if err != nil {
+		t.Fatalf("old token rejected during overlap: %v", err)
+	}
+
+	now = now.Add(3 * time.Hour)
+
+	_, err = ring.ValidateJWT(old)
+	if err == nil {
+		t.Fatal("old token accepted after overlap")
+	}
+
+	_, err = ring.ValidateJWT(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.keys) != 2 {
+		t.Fatalf("expected 2 stored keys, got %d", len(store.keys))
+	}
+}
+
+func TestKeyRingOverlapShorterThanTokens(t *testing.T) {
+	_, err := NewKeyRing(&memoryKeyStore{}, "ES256", DefaultTokenConfig, time.Hour, time.Minute, nil)
+	if err == nil {
+		t.Fatal("expected an error for an overlap shorter than the token lifetime")
+	}
+}
+
+func TestKeyRingEncryptsKeys(t *testing.T) {
+	store := &memoryKeyStore{}
+	sealer, err := NewSealer([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ring, err := NewKeyRing(store, "ES256", DefaultTokenConfig, time.Hour, 2*time.Hour, sealer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(store.keys[0].PrivateKey, "BEGIN PRIVATE KEY") {
+		t.Fatal("private key stored in the clear")
+	}
+
+	token, err := ring.MakeJWT(uuid.New(), UserClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another instance with the same key reads it back.
+	other, err := NewKeyRing(store, "ES256", DefaultTokenConfig, time.Hour, 2*time.Hour, sealer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = other.ValidateJWT(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrong, err := NewSealer([]byte("fedcba9876543210fedcba9876543210"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewKeyRing(store, "ES256", DefaultTokenConfig, time.Hour, 2*time.Hour, wrong)
+	if err == nil {
+		t.Fatal("expected an error with the wrong encryption key")
+	}
+
+	_, err = NewKeyRing(store, "ES256", DefaultTokenConfig, time.Hour, 2*time.Hour, nil)
+	if err == nil {
+		t.Fatal("expected an error without an encryption key")
+	}
+}
+
+func TestKeyRingRotatesOnceAcrossInstances(t *testing.T) {
+	now := time.Now()
+	store := &memoryKeyStore{}
+
+	first, err := NewKeyRing(store, "ES256", DefaultTokenConfig, time.Hour, 2*time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.now = func() time.Time { return now }
+
+	second, err := NewKeyRing(store, "ES256", DefaultTokenConfig, time.Hour, 2*time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.now = func() time.Time { return now }
+
+	now = now.Add(2 * time.Hour)
+
+	// The second instance rotates after the first decided a rotation was due
+	// but before it took the lock.
+	store.beforeTx = func() {
+		err := second.Sync(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = first.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.keys) != 2 {
+		t.Fatalf("expected one rotation, got %d keys", len(store.keys))
+	}
+
+	if first.JWKS().Keys[1].Kid != second.JWKS().Keys[1].Kid {
+		t.Fatal("instances disagree on the active key")
+	}
+}
+
+func TestKeyRingLookupDoesNotRotate(t *testing.T) {
+	now := time.Now()
+	store := &memoryKeyStore{}
+
+	ring, err := NewKeyRing(store, "ES256", DefaultTokenConfig, time.Hour, 2*time.Hour, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring.now = func() time.Time { return now }
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{})
+	unknown.Header["kid"] = "unknown"
+	signed, err := unknown.SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotation is due, but only the ticker's Sync may add a key.
+	now = now.Add(2 * time.Hour)
+
+	_, err = ring.ValidateJWT(signed)
+	if err == nil {
+		t.Fatal("token with an unknown kid accepted")
+	}
+
+	if len(store.keys) != 1 {
+		t.Fatalf("validation rotated the ring, got %d keys", len(store.keys))
+	}
+}
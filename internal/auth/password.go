@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+var (
+	ErrPasswordMismatch = errors.New("Password does not match")
+	ErrUnknownHash      = errors.New("Unrecognised password hash")
+)
+
+// Argon2Params are the argon2id cost parameters. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for argon2id.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+const DefaultBcryptCost = 12
+
+// PasswordHasher hashes new passwords with Algorithm and verifies hashes of
+// either algorithm, so existing bcrypt hashes keep working. Hashes are PHC
+// strings that carry their own parameters. At most Workers hashes are
+// computed at once; other callers wait for a free slot.
+type PasswordHasher struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+
+	slots chan struct{}
+}
+
+func NewPasswordHasher(algorithm string, argon2Params Argon2Params, bcryptCost, workers int) (*PasswordHasher, error) {
+	if algorithm != AlgorithmArgon2id && algorithm != AlgorithmBcrypt {
+		return nil, fmt.Errorf("Unsupported password hash %q", algorithm)
+	}
+
+	if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("Invalid bcrypt cost %d", bcryptCost)
+	}
+
+	if argon2Params.Memory == 0 || argon2Params.Iterations == 0 || argon2Params.Parallelism == 0 {
+		return nil, fmt.Errorf("Invalid argon2id parameters")
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &PasswordHasher{
+		Algorithm:  algorithm,
+		Argon2:     argon2Params,
+		BcryptCost: bcryptCost,
+		slots:      make(chan struct{}, workers),
+	}, nil
+}
+
+func (h *PasswordHasher) acquire(ctx context.Context) error {
+	select {
+	case h.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *PasswordHasher) release() {
+	<-h.slots
+}
+
+func (h *PasswordHasher) Hash(ctx context.Context, password string) (string, error) {
+	err := h.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer h.release()
+
+	if h.Algorithm == AlgorithmBcrypt {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+
+		return string(hashed), nil
+	}
+
+	salt := make([]byte, h.Argon2.SaltLength)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	return encodeArgon2id(h.Argon2, salt, argon2idKey(password, salt, h.Argon2)), nil
+}
+
+// Verify returns ErrPasswordMismatch when the password is wrong.
+func (h *PasswordHasher) Verify(ctx context.Context, password, hash string) error {
+	err := h.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer h.release()
+
+	return verifyPassword(password, hash)
+}
+
+// NeedsRehash reports whether hash was made with another algorithm or with
+// weaker parameters than the hasher now uses.
+func (h *PasswordHasher) NeedsRehash(hash string) bool {
+	switch h.Algorithm {
+	case AlgorithmArgon2id:
+		params, _, _, err := decodeArgon2id(hash)
+		return err != nil || params != h.Argon2
+	case AlgorithmBcrypt:
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost < h.BcryptCost
+	}
+
+	return false
+}
+
+func verifyPassword(password, hash string) error {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return err
+		}
+
+		if subtle.ConstantTimeCompare(key, argon2idKey(password, salt, params)) != 1 {
+			return ErrPasswordMismatch
+		}
+
+		return nil
+
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrPasswordMismatch
+		}
+
+		return err
+	}
+
+	return ErrUnknownHash
+}
+
+func argon2idKey(password string, salt []byte, params Argon2Params) []byte {
+	return argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+}
+
+// encodeArgon2id writes the PHC string
+// $argon2id$v=19$m=<memory>,t=<iterations>,p=<parallelism>$<salt>$<key>.
+func encodeArgon2id(params Argon2Params, salt, key []byte) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		params.Memory,
+		params.Iterations,
+		params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+}
+
+func decodeArgon2id(hash string) (Argon2Params, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2Params{}, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, ErrUnknownHash
+	}
+
+	params := Argon2Params{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return Argon2Params{}, nil, nil, ErrUnknownHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, ErrUnknownHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2Params{}, nil, nil, ErrUnknownHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
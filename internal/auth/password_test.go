@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testArgon2Params = Argon2Params{
+	Memory:      64,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestPasswordHasherArgon2id(t *testing.T) {
+	hasher, err := NewPasswordHasher(AlgorithmArgon2id, testArgon2Params, bcrypt.MinCost, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := hasher.Hash(context.Background(), "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("unexpected PHC string %q", hash)
+	}
+
+	err = hasher.Verify(context.Background(), "hunter2", hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = hasher.Verify(context.Background(), "hunter3", hash)
+	if !errors.Is(err, ErrPasswordMismatch) {
+		t.Fatalf("got %v, want ErrPasswordMismatch", err)
+	}
+
+	if hasher.NeedsRehash(hash) {
+		t.Fatal("fresh hash needs a rehash")
+	}
+
+	stronger := *hasher
+	stronger.Argon2.Iterations = 2
+	if !stronger.NeedsRehash(hash) {
+		t.Fatal("hash with weaker parameters does not need a rehash")
+	}
+}
+
+func TestPasswordHasherLegacyBcrypt(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hasher, err := NewPasswordHasher(AlgorithmArgon2id, testArgon2Params, bcrypt.MinCost, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = hasher.Verify(context.Background(), "hunter2", string(legacy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = hasher.Verify(context.Background(), "hunter3", string(legacy))
+	if !errors.Is(err, ErrPasswordMismatch) {
+		t.Fatalf("got %v, want ErrPasswordMismatch", err)
+	}
+
+	if !hasher.NeedsRehash(string(legacy)) {
+		t.Fatal("bcrypt hash does not need a rehash to argon2id")
+	}
+
+	bcryptHasher, err := NewPasswordHasher(AlgorithmBcrypt, testArgon2Params, bcrypt.MinCost+1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bcryptHasher.NeedsRehash(string(legacy)) {
+		t.Fatal("bcrypt hash below the configured cost does not need a rehash")
+	}
+}
+
+func TestPasswordHasherMalformed(t *testing.T) {
+	for _, hash := range []string{"", "plaintext", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA", "$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5"} {
+		err := CheckPasswordHash("hunter2", hash)
+		if !errors.Is(err, ErrUnknownHash) {
+			t.Fatalf("%q: got %v, want ErrUnknownHash", hash, err)
+		}
+	}
+}
+
+func TestPasswordHasherBoundsWorkers(t *testing.T) {
+	hasher, err := NewPasswordHasher(AlgorithmArgon2id, testArgon2Params, bcrypt.MinCost, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Occupy the only worker slot.
+	hasher.slots <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err = hasher.Hash(ctx, "hunter2")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want to wait for a free worker", err)
+	}
+
+	<-hasher.slots
+
+	_, err = hasher.Hash(context.Background(), "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
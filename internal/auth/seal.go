@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+var ErrSealedValueInvalid = errors.New("sealed value could not be decrypted")
+
+// Sealer encrypts secrets the database has to keep, such as signing keys and
+// TOTP secrets, with AES-GCM, so that a copy of the database alone does not
+// give them away.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// NewSealer takes a 32 byte key.
+func NewSealer(key []byte) (*Sealer, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("Encryption key must be 32 bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sealer{aead: aead}, nil
+}
+
+// Seal encrypts plaintext under a random nonce, which it prepends. The
+// additional data, typically the row's key, is authenticated along with it,
+// so a sealed value cannot be copied into another row.
+func (s *Sealer) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open reverses Seal.
+func (s *Sealer) Open(sealed, additionalData []byte) ([]byte, error) {
+	size := s.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, ErrSealedValueInvalid
+	}
+
+	plaintext, err := s.aead.Open(nil, sealed[:size], sealed[size:], additionalData)
+	if err != nil {
+		return nil, ErrSealedValueInvalid
+	}
+
+	return plaintext, nil
+}
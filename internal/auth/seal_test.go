@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSealer(t *testing.T) {
+	sealer, err := NewSealer([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := sealer.Seal([]byte("secret"), []byte("row-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(sealed, []byte("secret")) {
+		t.Fatal("plaintext is visible in the sealed value")
+	}
+
+	opened, err := sealer.Open(sealed, []byte("row-1"))
+	if err != nil || string(opened) != "secret" {
+		t.Fatalf("got %q, %v", opened, err)
+	}
+
+	// A sealed value moved to another row does not open.
+	_, err = sealer.Open(sealed, []byte("row-2"))
+	if !errors.Is(err, ErrSealedValueInvalid) {
+		t.Fatalf("got %v, want ErrSealedValueInvalid", err)
+	}
+
+	_, err = sealer.Open(sealed[:4], []byte("row-1"))
+	if !errors.Is(err, ErrSealedValueInvalid) {
+		t.Fatalf("got %v, want ErrSealedValueInvalid", err)
+	}
+
+	_, err = NewSealer([]byte("too short"))
+	if err == nil {
+		t.Fatal("expected an error for a short key")
+	}
+}
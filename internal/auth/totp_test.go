@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA1, truncated to six digits.
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		got, err := TOTPCode(secret, TOTPStep(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("T=%d: got %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := MakeTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1_700_000_000, 0)
+
+	code, err := TOTPCode(secret, TOTPStep(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step, ok := ValidateTOTP(secret, code, now.Add(totpPeriod*time.Second))
+	if !ok || step != TOTPStep(now) {
+		t.Fatalf("code from the previous step rejected")
+	}
+
+	_, ok = ValidateTOTP(secret, code, now.Add(3*totpPeriod*time.Second))
+	if ok {
+		t.Fatal("stale code accepted")
+	}
+
+	_, ok = ValidateTOTP(secret, "12345", now)
+	if ok {
+		t.Fatal("short code accepted")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(TOTPURI("Chirpy", "walt@example.com", "JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Chirpy:walt@example.com" {
+		t.Fatalf("unexpected URI %s", uri)
+	}
+
+	if uri.Query().Get("secret") != "JBSWY3DPEHPK3PXP" || uri.Query().Get("issuer") != "Chirpy" {
+		t.Fatalf("unexpected query %s", uri.RawQuery)
+	}
+}
+
+func TestRecoveryCode(t *testing.T) {
+	code, err := MakeRecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(code) != 11 || code[5] != '-' {
+		t.Fatalf("unexpected code %q", code)
+	}
+
+	loose := strings.ToUpper(strings.Replace(code, "-", " ", 1))
+	if HashRecoveryCode(loose) != HashRecoveryCode(code) {
+		t.Fatal("hash depends on formatting")
+	}
+}
@@ -7,29 +7,40 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps(id, created_at, updated_at, body, user_id)
+INSERT INTO chirps(id, created_at, updated_at, body, user_id, parent_id, root_id)
 VALUES (
 	gen_random_uuid(),
 	NOW(),
 	NOW(),
 	$1,
-	$2
+	$2,
+	$3,
+	$4
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, edited, parent_id, root_id, reply_count, deleted_at, like_count, fanned_out_at
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body     string
+	UserID   uuid.UUID
+	ParentID uuid.NullUUID
+	RootID   uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.Body,
+		arg.UserID,
+		arg.ParentID,
+		arg.RootID,
+	)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -37,6 +48,13 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.Edited,
+		&i.ParentID,
+		&i.RootID,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
+		&i.FannedOutAt,
 	)
 	return i, err
 }
@@ -50,12 +68,37 @@ func (q *Queries) DeleteChirpById(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
-const getAllChirps = `-- name: GetAllChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps ORDER BY created_at ASC
+const deleteChirpWithoutReplies = `-- name: DeleteChirpWithoutReplies :execrows
+DELETE FROM chirps
+WHERE id = $1
+AND NOT EXISTS (SELECT 1 FROM chirps AS replies WHERE replies.parent_id = $1)
 `
 
-func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirps)
+func (q *Queries) DeleteChirpWithoutReplies(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteChirpWithoutReplies, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getChirpAncestors = `-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors(id, parent_id) AS (
+	SELECT parent.id, parent.parent_id
+	FROM chirps AS parent
+	WHERE parent.id = (SELECT child.parent_id FROM chirps AS child WHERE child.id = $1)
+	UNION ALL
+	SELECT parent.id, parent.parent_id
+	FROM chirps AS parent
+	JOIN ancestors ON parent.id = ancestors.parent_id
+)
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edited, chirps.parent_id, chirps.root_id, chirps.reply_count, chirps.deleted_at, chirps.like_count, chirps.fanned_out_at FROM chirps
+WHERE chirps.id IN (SELECT ancestors.id FROM ancestors)
+ORDER BY chirps.created_at ASC, chirps.id ASC
+`
+
+func (q *Queries) GetChirpAncestors(ctx context.Context, id uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpAncestors, id)
 	if err != nil {
 		return nil, err
 	}
@@ -69,6 +112,13 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.Edited,
+			&i.ParentID,
+			&i.RootID,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.FannedOutAt,
 		); err != nil {
 			return nil, err
 		}
@@ -83,12 +133,53 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getAllChirpsByAuthorId = `-- name: GetAllChirpsByAuthorId :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE user_id = $1 ORDER BY created_at ASC
+const getChirpById = `-- name: GetChirpById :one
+SELECT id, created_at, updated_at, body, user_id, edited, parent_id, root_id, reply_count, deleted_at, like_count, fanned_out_at FROM chirps WHERE id = $1
+`
+
+func (q *Queries) GetChirpById(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpById, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.Edited,
+		&i.ParentID,
+		&i.RootID,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
+		&i.FannedOutAt,
+	)
+	return i, err
+}
+
+const getChirpDescendants = `-- name: GetChirpDescendants :many
+WITH RECURSIVE descendants(id) AS (
+	SELECT reply.id
+	FROM chirps AS reply
+	WHERE reply.parent_id = $1
+	UNION ALL
+	SELECT reply.id
+	FROM chirps AS reply
+	JOIN descendants ON reply.parent_id = descendants.id
+)
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edited, chirps.parent_id, chirps.root_id, chirps.reply_count, chirps.deleted_at, chirps.like_count, chirps.fanned_out_at FROM chirps
+WHERE chirps.id IN (SELECT descendants.id FROM descendants)
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT $2
 `
 
-func (q *Queries) GetAllChirpsByAuthorId(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirpsByAuthorId, userID)
+type GetChirpDescendantsParams struct {
+	ParentID uuid.NullUUID
+	Limit    int32
+}
+
+func (q *Queries) GetChirpDescendants(ctx context.Context, arg GetChirpDescendantsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpDescendants, arg.ParentID, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -102,6 +193,13 @@ func (q *Queries) GetAllChirpsByAuthorId(ctx context.Context, userID uuid.UUID)
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.Edited,
+			&i.ParentID,
+			&i.RootID,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.FannedOutAt,
 		); err != nil {
 			return nil, err
 		}
@@ -116,12 +214,138 @@ func (q *Queries) GetAllChirpsByAuthorId(ctx context.Context, userID uuid.UUID)
 	return items, nil
 }
 
-const getChirpById = `-- name: GetChirpById :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE id = $1
+const getChirpsPageAsc = `-- name: GetChirpsPageAsc :many
+SELECT id, created_at, updated_at, body, user_id, edited, parent_id, root_id, reply_count, deleted_at, like_count, fanned_out_at FROM chirps
+WHERE deleted_at IS NULL
+AND ($1::uuid IS NULL OR user_id = $1::uuid)
+AND ($2::uuid IS NULL OR parent_id = $2::uuid)
+AND (
+	$3::timestamp IS NULL
+	OR (created_at, id) > ($3::timestamp, $4::uuid)
+)
+ORDER BY created_at ASC, id ASC
+LIMIT $5
 `
 
-func (q *Queries) GetChirpById(ctx context.Context, id uuid.UUID) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, getChirpById, id)
+type GetChirpsPageAscParams struct {
+	AuthorID        uuid.NullUUID
+	ParentID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageSize        int32
+}
+
+func (q *Queries) GetChirpsPageAsc(ctx context.Context, arg GetChirpsPageAscParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsPageAsc,
+		arg.AuthorID,
+		arg.ParentID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.Edited,
+			&i.ParentID,
+			&i.RootID,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.FannedOutAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsPageDesc = `-- name: GetChirpsPageDesc :many
+SELECT id, created_at, updated_at, body, user_id, edited, parent_id, root_id, reply_count, deleted_at, like_count, fanned_out_at FROM chirps
+WHERE deleted_at IS NULL
+AND ($1::uuid IS NULL OR user_id = $1::uuid)
+AND ($2::uuid IS NULL OR parent_id = $2::uuid)
+AND (
+	$3::timestamp IS NULL
+	OR (created_at, id) < ($3::timestamp, $4::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT $5
+`
+
+type GetChirpsPageDescParams struct {
+	AuthorID        uuid.NullUUID
+	ParentID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageSize        int32
+}
+
+func (q *Queries) GetChirpsPageDesc(ctx context.Context, arg GetChirpsPageDescParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsPageDesc,
+		arg.AuthorID,
+		arg.ParentID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.Edited,
+			&i.ParentID,
+			&i.RootID,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.FannedOutAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockChirpById = `-- name: LockChirpById :one
+SELECT id, created_at, updated_at, body, user_id, edited, parent_id, root_id, reply_count, deleted_at, like_count, fanned_out_at FROM chirps WHERE id = $1 FOR UPDATE
+`
+
+func (q *Queries) LockChirpById(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, lockChirpById, id)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -129,6 +353,124 @@ func (q *Queries) GetChirpById(ctx context.Context, id uuid.UUID) (Chirp, error)
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.Edited,
+		&i.ParentID,
+		&i.RootID,
+		&i.ReplyCount,
+		&i.DeletedAt,
+		&i.LikeCount,
+		&i.FannedOutAt,
 	)
 	return i, err
 }
+
+const searchChirps = `-- name: SearchChirps :many
+SELECT
+	chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.edited, chirps.parent_id, chirps.root_id, chirps.reply_count, chirps.deleted_at, chirps.like_count, chirps.fanned_out_at,
+	ts_rank_cd(to_tsvector('english', body), search_query)::real AS rank,
+	ts_headline(
+		'english',
+		replace(replace(replace(replace(replace(body, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;'),
+		search_query,
+		'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'
+	)::text AS headline
+FROM chirps, to_tsquery('english', $1::text) search_query
+WHERE to_tsvector('english', body) @@ search_query
+AND deleted_at IS NULL
+AND ($2::uuid IS NULL OR user_id = $2::uuid)
+AND ($3::timestamp IS NULL OR created_at >= $3::timestamp)
+AND ($4::timestamp IS NULL OR created_at < $4::timestamp)
+ORDER BY rank DESC, created_at DESC, id DESC
+LIMIT $5 OFFSET $6
+`
+
+type SearchChirpsParams struct {
+	Query      string
+	AuthorID   uuid.NullUUID
+	Since      sql.NullTime
+	Until      sql.NullTime
+	PageSize   int32
+	PageOffset int32
+}
+
+type SearchChirpsRow struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Body        string
+	UserID      uuid.UUID
+	Edited      bool
+	ParentID    uuid.NullUUID
+	RootID      uuid.NullUUID
+	ReplyCount  int32
+	DeletedAt   sql.NullTime
+	LikeCount   int32
+	FannedOutAt sql.NullTime
+	Rank        float32
+	Headline    string
+}
+
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps,
+		arg.Query,
+		arg.AuthorID,
+		arg.Since,
+		arg.Until,
+		arg.PageSize,
+		arg.PageOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsRow
+	for rows.Next() {
+		var i SearchChirpsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.Edited,
+			&i.ParentID,
+			&i.RootID,
+			&i.ReplyCount,
+			&i.DeletedAt,
+			&i.LikeCount,
+			&i.FannedOutAt,
+			&i.Rank,
+			&i.Headline,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const tombstoneChirpById = `-- name: TombstoneChirpById :exec
+WITH removed_revisions AS (
+	DELETE FROM chirp_revisions WHERE chirp_id = $1
+), removed_hashtags AS (
+	DELETE FROM chirp_hashtags WHERE chirp_id = $1
+), removed_mentions AS (
+	DELETE FROM mentions WHERE chirp_id = $1
+), removed_media AS (
+	DELETE FROM media WHERE chirp_id = $1
+)
+UPDATE chirps
+SET body = '', edited = false, deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) TombstoneChirpById(ctx context.Context, chirpID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, tombstoneChirpById, chirpID)
+	return err
+}
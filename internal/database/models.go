@@ -12,11 +12,132 @@ import (
 )
 
 type Chirp struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Body        string
+	UserID      uuid.UUID
+	Edited      bool
+	ParentID    uuid.NullUUID
+	RootID      uuid.NullUUID
+	ReplyCount  int32
+	DeletedAt   sql.NullTime
+	LikeCount   int32
+	FannedOutAt sql.NullTime
+}
+
+type ChirpHashtag struct {
+	ChirpID   uuid.UUID
+	HashtagID uuid.UUID
+	CreatedAt time.Time
+}
+
+type ChirpRevision struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
-	UpdatedAt time.Time
+	ChirpID   uuid.UUID
 	Body      string
+}
+
+type EmailVerificationToken struct {
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
 	UserID    uuid.UUID
+	Email     string
+}
+
+type Follow struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+}
+
+type Hashtag struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	Tag       string
+}
+
+type Like struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	ChirpID   uuid.UUID
+}
+
+type LoginThrottle struct {
+	Scope         string
+	Key           string
+	Failures      int32
+	LastFailureAt time.Time
+	BlockedUntil  sql.NullTime
+}
+
+type Medium struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UserID       uuid.UUID
+	ChirpID      uuid.NullUUID
+	Position     int32
+	ContentType  string
+	SizeBytes    int64
+	Width        int32
+	Height       int32
+	AltText      string
+	StorageKey   string
+	ThumbnailKey string
+}
+
+type Mention struct {
+	ChirpID     uuid.UUID
+	UserID      uuid.UUID
+	StartOffset int32
+	EndOffset   int32
+}
+
+type MfaChallenge struct {
+	TokenHash  string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	UserID     uuid.UUID
+	DeviceName string
+	Attempts   int32
+}
+
+type Notification struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	ActorID   uuid.NullUUID
+	Kind      string
+	ChirpID   uuid.NullUUID
+	ReadAt    sql.NullTime
+}
+
+type OauthState struct {
+	StateHash    string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	Provider     string
+	CodeVerifier string
+	Nonce        string
+	DeviceName   string
+}
+
+type PasswordResetToken struct {
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UserID    uuid.UUID
+}
+
+type RecoveryCode struct {
+	CodeHash  string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	UsedAt    sql.NullTime
 }
 
 type RefreshToken struct {
@@ -26,13 +147,80 @@ type RefreshToken struct {
 	UserID    uuid.UUID
 	ExpiresAt time.Time
 	RevokedAt sql.NullTime
+	SessionID uuid.UUID
+	RotatedAt sql.NullTime
+}
+
+type SecurityEvent struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	Kind      string
+	SessionID uuid.NullUUID
+	IpAddress string
+	UserAgent string
+}
+
+type Session struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UserID     uuid.UUID
+	DeviceName string
+	UserAgent  string
+	IpAddress  string
+	LastUsedAt time.Time
+	RevokedAt  sql.NullTime
+}
+
+type SigningKey struct {
+	Kid        string
+	CreatedAt  time.Time
+	Algorithm  string
+	PrivateKey string
+	RetiresAt  sql.NullTime
+}
+
+type TimelineEntry struct {
+	UserID    uuid.UUID
+	ChirpID   uuid.UUID
+	AuthorID  uuid.UUID
+	CreatedAt time.Time
+}
+
+type TotpCredential struct {
+	UserID       uuid.UUID
+	CreatedAt    time.Time
+	Secret       string
+	ConfirmedAt  sql.NullTime
+	LastUsedStep int64
 }
 
 type User struct {
-	ID             uuid.UUID
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
-	IsChirpyRed    sql.NullBool
+	ID              uuid.UUID
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Email           string
+	HashedPassword  string
+	IsChirpyRed     sql.NullBool
+	FollowerCount   int32
+	FollowingCount  int32
+	Handle          sql.NullString
+	DisplayName     string
+	Bio             string
+	Location        string
+	Website         string
+	AvatarMediaID   uuid.NullUUID
+	EmailVerifiedAt sql.NullTime
+	Role            string
+	TokenVersion    int32
+	SuspendedAt     sql.NullTime
+}
+
+type UserIdentity struct {
+	Provider    string
+	Subject     string
+	CreatedAt   time.Time
+	UserID      uuid.UUID
+	Email       string
+	LastLoginAt time.Time
 }
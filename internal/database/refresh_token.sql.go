@@ -7,30 +7,39 @@ package database
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 const createRefreshToken = `-- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens(token, created_at, updated_at, user_id, expires_at, revoked_at)
+INSERT INTO refresh_tokens(token, created_at, updated_at, user_id, expires_at, revoked_at, session_id)
 VALUES (
 	$1,
 	NOW(),
 	NOW(),
 	$2,
-	NOW() + INTERVAL '60days',
-	NULL
+	$4,
+	NULL,
+	$3
 )
-RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at, session_id, rotated_at
 `
 
 type CreateRefreshTokenParams struct {
-	Token  string
-	UserID uuid.UUID
+	Token     string
+	UserID    uuid.UUID
+	SessionID uuid.UUID
+	ExpiresAt time.Time
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, createRefreshToken, arg.Token, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createRefreshToken,
+		arg.Token,
+		arg.UserID,
+		arg.SessionID,
+		arg.ExpiresAt,
+	)
 	var i RefreshToken
 	err := row.Scan(
 		&i.Token,
@@ -39,12 +48,14 @@ func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshToken
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.SessionID,
+		&i.RotatedAt,
 	)
 	return i, err
 }
 
 const getRefreshTokenByToken = `-- name: GetRefreshTokenByToken :one
-SELECT token, created_at, updated_at, user_id, expires_at, revoked_at FROM refresh_tokens WHERE token = $1
+SELECT token, created_at, updated_at, user_id, expires_at, revoked_at, session_id, rotated_at FROM refresh_tokens WHERE token = $1
 `
 
 func (q *Queries) GetRefreshTokenByToken(ctx context.Context, token string) (RefreshToken, error) {
@@ -57,46 +68,72 @@ func (q *Queries) GetRefreshTokenByToken(ctx context.Context, token string) (Ref
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.SessionID,
+		&i.RotatedAt,
 	)
 	return i, err
 }
 
-const getRefreshTokenByUserId = `-- name: GetRefreshTokenByUserId :one
-SELECT token, created_at, updated_at, user_id, expires_at, revoked_at FROM refresh_tokens WHERE user_id = $1
+const revokeRefreshTokenByToken = `-- name: RevokeRefreshTokenByToken :exec
+WITH revoked_session AS (
+	UPDATE sessions
+	SET revoked_at = NOW()
+	WHERE sessions.id = (SELECT refresh_tokens.session_id FROM refresh_tokens WHERE refresh_tokens.token = $1)
+	AND sessions.revoked_at IS NULL
+)
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE token = $1
 `
 
-func (q *Queries) GetRefreshTokenByUserId(ctx context.Context, userID uuid.UUID) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, getRefreshTokenByUserId, userID)
-	var i RefreshToken
-	err := row.Scan(
-		&i.Token,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-		&i.UserID,
-		&i.ExpiresAt,
-		&i.RevokedAt,
-	)
-	return i, err
+func (q *Queries) RevokeRefreshTokenByToken(ctx context.Context, token string) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokenByToken, token)
+	return err
 }
 
-const refreshTokenByToken = `-- name: RefreshTokenByToken :exec
+const revokeRefreshTokensByUserId = `-- name: RevokeRefreshTokensByUserId :exec
+WITH revoked_sessions AS (
+	UPDATE sessions
+	SET revoked_at = NOW()
+	WHERE sessions.user_id = $1 AND sessions.revoked_at IS NULL
+)
 UPDATE refresh_tokens
-SET expires_at = NOW() + INTERVAL '60 days', updated_at = NOW()
-WHERE token = $1
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL
 `
 
-func (q *Queries) RefreshTokenByToken(ctx context.Context, token string) error {
-	_, err := q.db.ExecContext(ctx, refreshTokenByToken, token)
+func (q *Queries) RevokeRefreshTokensByUserId(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokensByUserId, userID)
 	return err
 }
 
-const revokeRefreshTokenByToken = `-- name: RevokeRefreshTokenByToken :exec
+const rotateRefreshToken = `-- name: RotateRefreshToken :one
 UPDATE refresh_tokens
-SET revoked_at = NOW(), updated_at = NOW()
+SET rotated_at = NOW(), updated_at = NOW()
 WHERE token = $1
+AND rotated_at IS NULL
+AND revoked_at IS NULL
+AND expires_at > $2
+RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at, session_id, rotated_at
 `
 
-func (q *Queries) RevokeRefreshTokenByToken(ctx context.Context, token string) error {
-	_, err := q.db.ExecContext(ctx, revokeRefreshTokenByToken, token)
-	return err
+type RotateRefreshTokenParams struct {
+	Token string
+	Now   time.Time
+}
+
+func (q *Queries) RotateRefreshToken(ctx context.Context, arg RotateRefreshTokenParams) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, rotateRefreshToken, arg.Token, arg.Now)
+	var i RefreshToken
+	err := row.Scan(
+		&i.Token,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.SessionID,
+		&i.RotatedAt,
+	)
+	return i, err
 }
@@ -7,29 +7,46 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
 )
 
+const bumpTokenVersion = `-- name: BumpTokenVersion :one
+UPDATE users
+SET token_version = token_version + 1
+WHERE id = $1
+RETURNING token_version
+`
+
+func (q *Queries) BumpTokenVersion(ctx context.Context, id uuid.UUID) (int32, error) {
+	row := q.db.QueryRowContext(ctx, bumpTokenVersion, id)
+	var token_version int32
+	err := row.Scan(&token_version)
+	return token_version, err
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO users(id, created_at, updated_at, email, hashed_password)
+INSERT INTO users(id, created_at, updated_at, email, hashed_password, handle)
 VALUES (
 	gen_random_uuid(),
 	NOW(),
 	NOW(),
 	$1,
-	$2
+	$2,
+	$3
 )
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, follower_count, following_count, handle, display_name, bio, location, website, avatar_media_id, email_verified_at, role, token_version, suspended_at
 `
 
 type CreateUserParams struct {
 	Email          string
 	HashedPassword string
+	Handle         sql.NullString
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword)
+	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword, arg.Handle)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -38,6 +55,18 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Location,
+		&i.Website,
+		&i.AvatarMediaID,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.SuspendedAt,
 	)
 	return i, err
 }
@@ -52,7 +81,7 @@ func (q *Queries) DeleteAllUsers(ctx context.Context) error {
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users WHERE email = $1
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, follower_count, following_count, handle, display_name, bio, location, website, avatar_media_id, email_verified_at, role, token_version, suspended_at FROM users WHERE lower(email) = lower($1)
 `
 
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
@@ -65,25 +94,177 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Location,
+		&i.Website,
+		&i.AvatarMediaID,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.SuspendedAt,
+	)
+	return i, err
+}
+
+const getUserByHandle = `-- name: GetUserByHandle :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, follower_count, following_count, handle, display_name, bio, location, website, avatar_media_id, email_verified_at, role, token_version, suspended_at FROM users WHERE handle = $1
+`
+
+func (q *Queries) GetUserByHandle(ctx context.Context, handle sql.NullString) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByHandle, handle)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Location,
+		&i.Website,
+		&i.AvatarMediaID,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.SuspendedAt,
+	)
+	return i, err
+}
+
+const getUserById = `-- name: GetUserById :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, follower_count, following_count, handle, display_name, bio, location, website, avatar_media_id, email_verified_at, role, token_version, suspended_at FROM users WHERE id = $1
+`
+
+func (q *Queries) GetUserById(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserById, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Location,
+		&i.Website,
+		&i.AvatarMediaID,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.SuspendedAt,
+	)
+	return i, err
+}
+
+const getUserTokenState = `-- name: GetUserTokenState :one
+SELECT token_version, suspended_at, EXISTS (
+	SELECT 1 FROM sessions
+	WHERE sessions.id = $1::uuid
+	AND sessions.revoked_at IS NOT NULL
+)::boolean AS session_revoked
+FROM users WHERE id = $2
+`
+
+type GetUserTokenStateParams struct {
+	SessionID uuid.NullUUID
+	ID        uuid.UUID
+}
+
+type GetUserTokenStateRow struct {
+	TokenVersion   int32
+	SuspendedAt    sql.NullTime
+	SessionRevoked bool
+}
+
+func (q *Queries) GetUserTokenState(ctx context.Context, arg GetUserTokenStateParams) (GetUserTokenStateRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserTokenState, arg.SessionID, arg.ID)
+	var i GetUserTokenStateRow
+	err := row.Scan(
+		&i.TokenVersion,
+		&i.SuspendedAt,
+		&i.SessionRevoked,
 	)
 	return i, err
 }
 
-const updateUserEmailAndPasswordById = `-- name: UpdateUserEmailAndPasswordById :one
+const rehashUserPassword = `-- name: RehashUserPassword :execrows
 UPDATE users
-SET email = $1, hashed_password = $2
-WHERE id = $3
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+SET hashed_password = $1
+WHERE id = $2 AND hashed_password = $3
 `
 
-type UpdateUserEmailAndPasswordByIdParams struct {
-	Email          string
-	HashedPassword string
-	ID             uuid.UUID
+type RehashUserPasswordParams struct {
+	NewHash string
+	ID      uuid.UUID
+	OldHash string
 }
 
-func (q *Queries) UpdateUserEmailAndPasswordById(ctx context.Context, arg UpdateUserEmailAndPasswordByIdParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, updateUserEmailAndPasswordById, arg.Email, arg.HashedPassword, arg.ID)
+func (q *Queries) RehashUserPassword(ctx context.Context, arg RehashUserPasswordParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rehashUserPassword, arg.NewHash, arg.ID, arg.OldHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const suspendUser = `-- name: SuspendUser :one
+UPDATE users
+SET suspended_at = COALESCE(suspended_at, NOW()),
+	token_version = token_version + 1,
+	updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, follower_count, following_count, handle, display_name, bio, location, website, avatar_media_id, email_verified_at, role, token_version, suspended_at
+`
+
+func (q *Queries) SuspendUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, suspendUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Location,
+		&i.Website,
+		&i.AvatarMediaID,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.SuspendedAt,
+	)
+	return i, err
+}
+
+const unsuspendUser = `-- name: UnsuspendUser :one
+UPDATE users
+SET suspended_at = NULL, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, follower_count, following_count, handle, display_name, bio, location, website, avatar_media_id, email_verified_at, role, token_version, suspended_at
+`
+
+func (q *Queries) UnsuspendUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, unsuspendUser, id)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -92,15 +273,113 @@ func (q *Queries) UpdateUserEmailAndPasswordById(ctx context.Context, arg Update
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Location,
+		&i.Website,
+		&i.AvatarMediaID,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.SuspendedAt,
 	)
 	return i, err
 }
 
+const updateUserById = `-- name: UpdateUserById :one
+UPDATE users
+SET email = COALESCE($1, email),
+	email_verified_at = CASE
+		WHEN $1 IS NULL OR lower(email) = lower($1) THEN email_verified_at
+	END,
+	hashed_password = COALESCE($2, hashed_password),
+	handle = CASE WHEN $3::boolean THEN $4 ELSE handle END,
+	display_name = COALESCE($5, display_name),
+	bio = COALESCE($6, bio),
+	location = COALESCE($7, location),
+	website = COALESCE($8, website),
+	avatar_media_id = CASE WHEN $9::boolean THEN $10 ELSE avatar_media_id END,
+	updated_at = NOW()
+WHERE id = $11
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, follower_count, following_count, handle, display_name, bio, location, website, avatar_media_id, email_verified_at, role, token_version, suspended_at
+`
+
+type UpdateUserByIdParams struct {
+	Email            sql.NullString
+	HashedPassword   sql.NullString
+	SetHandle        bool
+	Handle           sql.NullString
+	DisplayName      sql.NullString
+	Bio              sql.NullString
+	Location         sql.NullString
+	Website          sql.NullString
+	SetAvatarMediaID bool
+	AvatarMediaID    uuid.NullUUID
+	ID               uuid.UUID
+}
+
+func (q *Queries) UpdateUserById(ctx context.Context, arg UpdateUserByIdParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserById,
+		arg.Email,
+		arg.HashedPassword,
+		arg.SetHandle,
+		arg.Handle,
+		arg.DisplayName,
+		arg.Bio,
+		arg.Location,
+		arg.Website,
+		arg.SetAvatarMediaID,
+		arg.AvatarMediaID,
+		arg.ID,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Location,
+		&i.Website,
+		&i.AvatarMediaID,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.SuspendedAt,
+	)
+	return i, err
+}
+
+const updateUserPasswordById = `-- name: UpdateUserPasswordById :exec
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type UpdateUserPasswordByIdParams struct {
+	ID             uuid.UUID
+	HashedPassword string
+}
+
+func (q *Queries) UpdateUserPasswordById(ctx context.Context, arg UpdateUserPasswordByIdParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPasswordById, arg.ID, arg.HashedPassword)
+	return err
+}
+
 const upgradeUserById = `-- name: UpgradeUserById :one
 UPDATE users 
 SET is_chirpy_red = true
 WHERE ID = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, follower_count, following_count, handle, display_name, bio, location, website, avatar_media_id, email_verified_at, role, token_version, suspended_at
 `
 
 func (q *Queries) UpgradeUserById(ctx context.Context, id uuid.UUID) (User, error) {
@@ -113,6 +392,18 @@ func (q *Queries) UpgradeUserById(ctx context.Context, id uuid.UUID) (User, erro
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.FollowerCount,
+		&i.FollowingCount,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.Location,
+		&i.Website,
+		&i.AvatarMediaID,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.TokenVersion,
+		&i.SuspendedAt,
 	)
 	return i, err
 }
@@ -0,0 +1,189 @@
+package fanout
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+const (
+	// The repair pass looks for chirps whose job was dropped or lost in a
+	// restart every repairInterval, up to repairBatch at a time.
+	repairInterval = time.Minute
+	repairBatch    = 500
+)
+
+// Store is the subset of database.Queries the worker needs.
+type Store interface {
+	GetUserById(ctx context.Context, id uuid.UUID) (database.User, error)
+	FanOutChirp(ctx context.Context, id uuid.UUID) (int64, error)
+	GetPendingFanOuts(ctx context.Context, arg database.GetPendingFanOutsParams) ([]uuid.UUID, error)
+	DeleteTimelineEntriesByAuthor(ctx context.Context, arg database.DeleteTimelineEntriesByAuthorParams) (int64, error)
+	DeleteTimelineEntriesByChirpId(ctx context.Context, chirpID uuid.UUID) (int64, error)
+}
+
+type jobKind int
+
+const (
+	jobChirpCreated jobKind = iota
+	jobChirpDeleted
+	jobUnfollowed
+)
+
+type job struct {
+	kind     jobKind
+	chirpId  uuid.UUID
+	userId   uuid.UUID
+	authorId uuid.UUID
+}
+
+// Worker materialises home timelines in the background. Chirps from authors
+// with at least Threshold followers are never written out, and the timeline
+// query pulls in every chirp that has not been, so a chirp that is still
+// waiting for its job, or whose job was lost, is not missing from timelines.
+type Worker struct {
+	Threshold int32
+
+	db   Store
+	jobs chan job
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func New(db Store, threshold int32, queueSize int) *Worker {
+	return &Worker{
+		Threshold: threshold,
+		db:        db,
+		jobs:      make(chan job, queueSize),
+		stop:      make(chan struct{}),
+	}
+}
+
+func (w *Worker) Start(workers int) {
+	for range workers {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			for j := range w.jobs {
+				err := w.run(j)
+				if err != nil {
+					log.Printf("fanout: job %d failed: %v", j.kind, err)
+				}
+			}
+		}()
+	}
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(repairInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				err := w.repair(context.Background())
+				if err != nil {
+					log.Printf("fanout: repair failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop drains the queue and waits for the workers to finish.
+func (w *Worker) Stop() {
+	close(w.stop)
+	close(w.jobs)
+	w.wg.Wait()
+}
+
+func (w *Worker) ChirpCreated(chirp database.Chirp) {
+	w.enqueue(job{kind: jobChirpCreated, chirpId: chirp.ID, authorId: chirp.UserID})
+}
+
+func (w *Worker) ChirpDeleted(chirpId uuid.UUID) {
+	w.enqueue(job{kind: jobChirpDeleted, chirpId: chirpId})
+}
+
+func (w *Worker) Unfollowed(followerId, followeeId uuid.UUID) {
+	w.enqueue(job{kind: jobUnfollowed, userId: followerId, authorId: followeeId})
+}
+
+// enqueue never blocks the request that triggered the job. When the queue is
+// full the job is dropped, which timelines do not notice: a chirp that was
+// not fanned out is pulled on read until the repair pass catches up with it,
+// and entries for deleted chirps and unfollowed authors are filtered out.
+func (w *Worker) enqueue(j job) {
+	select {
+	case w.jobs <- j:
+	default:
+		log.Printf("fanout: queue full, dropping job %d", j.kind)
+	}
+}
+
+func (w *Worker) run(j job) error {
+	ctx := context.Background()
+
+	switch j.kind {
+	case jobChirpCreated:
+		pull, err := w.pulledOnRead(ctx, j.authorId)
+		if err != nil || pull {
+			return err
+		}
+
+		_, err = w.db.FanOutChirp(ctx, j.chirpId)
+		return err
+
+	case jobChirpDeleted:
+		_, err := w.db.DeleteTimelineEntriesByChirpId(ctx, j.chirpId)
+		return err
+
+	case jobUnfollowed:
+		_, err := w.db.DeleteTimelineEntriesByAuthor(ctx, database.DeleteTimelineEntriesByAuthorParams{
+			UserID:   j.userId,
+			AuthorID: j.authorId,
+		})
+		return err
+	}
+
+	return nil
+}
+
+// repair fans out recent chirps by authors below Threshold that never were,
+// because their job was dropped or lost, or because the author has since
+// lost followers.
+func (w *Worker) repair(ctx context.Context) error {
+	ids, err := w.db.GetPendingFanOuts(ctx, database.GetPendingFanOutsParams{
+		FanoutThreshold: w.Threshold,
+		BatchSize:       repairBatch,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		_, err = w.db.FanOutChirp(ctx, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *Worker) pulledOnRead(ctx context.Context, authorId uuid.UUID) (bool, error) {
+	author, err := w.db.GetUserById(ctx, authorId)
+	if err != nil {
+		return false, err
+	}
+
+	return author.FollowerCount >= w.Threshold, nil
+}
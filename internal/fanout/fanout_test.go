@@ -0,0 +1,103 @@
+package fanout
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/noueii/go-http-server/internal/database"
+)
+
+type fakeStore struct {
+	mu        sync.Mutex
+	followers map[uuid.UUID]int32
+	calls     []string
+}
+
+func (f *fakeStore) record(call string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+func (f *fakeStore) GetUserById(ctx context.Context, id uuid.UUID) (database.User, error) {
+	return database.User{ID: id, FollowerCount: f.followers[id]}, nil
+}
+
+func (f *fakeStore) FanOutChirp(ctx context.Context, id uuid.UUID) (int64, error) {
+	f.record("fanout")
+	return 1, nil
+}
+
+func (f *fakeStore) GetPendingFanOuts(ctx context.Context, arg database.GetPendingFanOutsParams) ([]uuid.UUID, error) {
+	f.record("pending")
+	return []uuid.UUID{uuid.New()}, nil
+}
+
+func (f *fakeStore) DeleteTimelineEntriesByAuthor(ctx context.Context, arg database.DeleteTimelineEntriesByAuthorParams) (int64, error) {
+	f.record("unfollow")
+	return 1, nil
+}
+
+func (f *fakeStore) DeleteTimelineEntriesByChirpId(ctx context.Context, chirpID uuid.UUID) (int64, error) {
+	f.record("delete")
+	return 1, nil
+}
+
+func TestWorkerSkipsLargeAccounts(t *testing.T) {
+	regular := uuid.New()
+	celebrity := uuid.New()
+	follower := uuid.New()
+
+	store := &fakeStore{followers: map[uuid.UUID]int32{regular: 10, celebrity: 50000}}
+	worker := New(store, 10000, 16)
+	worker.Start(1)
+
+	worker.ChirpCreated(database.Chirp{ID: uuid.New(), UserID: regular})
+	worker.ChirpCreated(database.Chirp{ID: uuid.New(), UserID: celebrity})
+	worker.Unfollowed(follower, celebrity)
+	worker.ChirpDeleted(uuid.New())
+	worker.Stop()
+
+	expected := []string{"fanout", "unfollow", "delete"}
+	if len(store.calls) != len(expected) {
+		t.Fatalf("Expected calls %v, got %v", expected, store.calls)
+	}
+	for i := range expected {
+		if store.calls[i] != expected[i] {
+			t.Fatalf("Expected calls %v, got %v", expected, store.calls)
+		}
+	}
+}
+
+func TestWorkerDropsWhenFull(t *testing.T) {
+	store := &fakeStore{}
+	worker := New(store, 10000, 1)
+
+	// No workers are running, so the second job finds the queue full and
+	// must not block.
+	worker.ChirpDeleted(uuid.New())
+	worker.ChirpDeleted(uuid.New())
+
+	worker.Start(1)
+	worker.Stop()
+
+	if len(store.calls) != 1 {
+		t.Fatalf("got calls %v, want a single delete", store.calls)
+	}
+}
+
+func TestWorkerRepairFansOutPending(t *testing.T) {
+	store := &fakeStore{}
+	worker := New(store, 10000, 1)
+
+	err := worker.repair(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.calls) != 2 || store.calls[0] != "pending" || store.calls[1] != "fanout" {
+		t.Fatalf("got calls %v, want pending then fanout", store.calls)
+	}
+}
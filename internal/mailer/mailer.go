@@ -0,0 +1,196 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by Queue.Send when the message was dropped.
+var ErrQueueFull = errors.New("mailer: queue is full")
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers plain text messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTP sends mail through a relay, using STARTTLS when the relay offers it.
+// Auth is only attempted when Username is set; net/smtp refuses plain auth
+// over unencrypted connections to anything but localhost. The whole exchange
+// is bounded by the context's deadline.
+type SMTP struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", s.Username, s.Password, host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(s.From)
+	if err != nil {
+		return err
+	}
+
+	err = client.Rcpt(msg.To)
+	if err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(format(s.From, msg))
+	if err != nil {
+		return err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// Log writes every message to W instead of delivering it, for development
+// and tests. Point it at a file to keep a mailbox on disk.
+type Log struct {
+	From string
+	W    io.Writer
+
+	mu sync.Mutex
+}
+
+func (l *Log) Send(ctx context.Context, msg Message) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err := fmt.Fprintf(l.W, "%s\r\n.\r\n", format(l.From, msg))
+	return err
+}
+
+// Queue hands messages to Mailer in the background, so requests never wait
+// on the relay. It holds at most size messages and gives each delivery
+// Timeout to finish. Failed deliveries are logged, not retried.
+type Queue struct {
+	Mailer  Mailer
+	Timeout time.Duration
+
+	messages chan Message
+	wg       sync.WaitGroup
+}
+
+func NewQueue(mailer Mailer, size int, timeout time.Duration) *Queue {
+	return &Queue{
+		Mailer:   mailer,
+		Timeout:  timeout,
+		messages: make(chan Message, size),
+	}
+}
+
+func (q *Queue) Start(workers int) {
+	for range workers {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for msg := range q.messages {
+				ctx, cancel := context.WithTimeout(context.Background(), q.Timeout)
+				err := q.Mailer.Send(ctx, msg)
+				cancel()
+
+				if err != nil {
+					log.Printf("mailer: could not deliver %q: %v", msg.Subject, err)
+				}
+			}
+		}()
+	}
+}
+
+// Stop delivers what is still queued and waits for the workers to finish.
+func (q *Queue) Stop() {
+	close(q.messages)
+	q.wg.Wait()
+}
+
+// Send queues msg without waiting for it to be delivered, or returns
+// ErrQueueFull.
+func (q *Queue) Send(ctx context.Context, msg Message) error {
+	select {
+	case q.messages <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func format(from string, msg Message) []byte {
+	headers := []string{
+		"From: " + from,
+		"To: " + msg.To,
+		"Subject: " + msg.Subject,
+		"Date: " + time.Now().UTC().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+	}
+
+	for i, header := range headers {
+		headers[i] = strings.NewReplacer("\r", "", "\n", "").Replace(header)
+	}
+
+	body := strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n")
+
+	return []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + body)
+}
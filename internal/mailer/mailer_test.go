@@ -0,0 +1,138 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP accepts a single message on a local port and hands back the
+// envelope recipient and the DATA section.
+func fakeSMTP(t *testing.T) (string, chan []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan []string, 1)
+
+	go func() {
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		text := textproto.NewConn(conn)
+		text.PrintfLine("220 localhost ESMTP")
+
+		rcpt, data := "", ""
+
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+
+			command := strings.ToUpper(line)
+
+			switch {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				text.PrintfLine("250 localhost")
+			case strings.HasPrefix(command, "RCPT TO:"):
+				rcpt = strings.Trim(line[len("RCPT TO:"):], "<>")
+				text.PrintfLine("250 OK")
+			case command == "DATA":
+				text.PrintfLine("354 Go ahead")
+				lines, _ := text.ReadDotLines()
+				data = strings.Join(lines, "\n")
+				text.PrintfLine("250 OK")
+			case command == "QUIT":
+				text.PrintfLine("221 Bye")
+				received <- []string{rcpt, data}
+				return
+			default:
+				text.PrintfLine("250 OK")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), received
+}
+
+func TestSMTP(t *testing.T) {
+	addr, received := fakeSMTP(t)
+
+	m := &SMTP{Addr: addr, From: "chirpy@example.com"}
+	err := m.Send(context.Background(), Message{
+		To:      "alice@example.com",
+		Subject: "Verify\r\nBcc: evil@example.com",
+		Body:    "Hello\nWorld",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-received
+
+	if got[0] != "alice@example.com" {
+		t.Fatalf("Expected recipient alice@example.com, got %s", got[0])
+	}
+
+	header, err := textproto.NewReader(bufio.NewReader(strings.NewReader(got[1] + "\n"))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Get("Bcc") != "" || header.Get("Subject") != "VerifyBcc: evil@example.com" {
+		t.Fatalf("Expected header injection to be neutralised, got %v", header)
+	}
+
+	if !strings.HasSuffix(got[1], "Hello\nWorld") {
+		t.Fatalf("Expected body at the end of the message, got %q", got[1])
+	}
+}
+
+func TestLog(t *testing.T) {
+	buf := bytes.Buffer{}
+	m := &Log{From: "chirpy@example.com", W: &buf}
+
+	err := m.Send(context.Background(), Message{To: "bob@example.com", Subject: "Hi", Body: "Link"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "To: bob@example.com\r\n") || !strings.Contains(buf.String(), "\r\n\r\nLink") {
+		t.Fatalf("Unexpected log output %q", buf.String())
+	}
+}
+
+func TestQueue(t *testing.T) {
+	buf := bytes.Buffer{}
+	q := NewQueue(&Log{From: "chirpy@example.com", W: &buf}, 1, time.Second)
+
+	err := q.Send(context.Background(), Message{To: "bob@example.com", Subject: "First"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is delivering yet, so the second message does not fit.
+	err = q.Send(context.Background(), Message{To: "bob@example.com", Subject: "Second"})
+	if err != ErrQueueFull {
+		t.Fatalf("Expected ErrQueueFull, got %v", err)
+	}
+
+	q.Start(1)
+	q.Stop()
+
+	if !strings.Contains(buf.String(), "Subject: First") || strings.Contains(buf.String(), "Subject: Second") {
+		t.Fatalf("Unexpected log output %q", buf.String())
+	}
+}
@@ -0,0 +1,382 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	ThumbnailSize = 320
+	MaxPixels     = 40_000_000
+	// MaxFrames and MaxPixels bound an animated GIF as a whole, since every
+	// frame is decoded into memory at once.
+	MaxFrames   = 500
+	jpegQuality = 90
+)
+
+var (
+	ErrUnsupportedType = errors.New("Unsupported media type")
+	ErrTooManyPixels   = errors.New("Image dimensions are too large")
+	ErrTooManyFrames   = errors.New("Animation has too many frames")
+)
+
+// Image is an upload after processing. Data has been re-encoded from the
+// decoded pixels, which drops EXIF and any other embedded metadata.
+type Image struct {
+	ContentType        string
+	Extension          string
+	Width              int
+	Height             int
+	Data               []byte
+	Thumbnail          []byte
+	ThumbnailExtension string
+}
+
+// Process sniffs the content type of an upload, rejects anything that is not
+// a JPEG, PNG or GIF, and returns a metadata-free copy plus a thumbnail no
+// larger than ThumbnailSize on either side. JPEG orientation tags are applied
+// to the pixels before the metadata is dropped.
+func Process(data []byte) (*Image, error) {
+	contentType := http.DetectContentType(data)
+
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return nil, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	out := &Image{ContentType: contentType}
+	buf := bytes.Buffer{}
+	var first image.Image
+
+	switch contentType {
+	case "image/jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+
+		first = orient(toRGBA(img), jpegOrientation(data))
+		out.Extension = "jpg"
+		err = jpeg.Encode(&buf, first, &jpeg.Options{Quality: jpegQuality})
+		if err != nil {
+			return nil, err
+		}
+
+	case "image/png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+
+		first = img
+		out.Extension = "png"
+		err = png.Encode(&buf, img)
+		if err != nil {
+			return nil, err
+		}
+
+	case "image/gif":
+		err := checkGIFFrames(data)
+		if err != nil {
+			return nil, err
+		}
+
+		anim, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+
+		first = anim.Image[0]
+		out.Extension = "gif"
+		err = gif.EncodeAll(&buf, &gif.GIF{
+			Image:           anim.Image,
+			Delay:           anim.Delay,
+			LoopCount:       anim.LoopCount,
+			Disposal:        anim.Disposal,
+			Config:          anim.Config,
+			BackgroundIndex: anim.BackgroundIndex,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	out.Data = buf.Bytes()
+	out.Width = first.Bounds().Dx()
+	out.Height = first.Bounds().Dy()
+
+	thumb := bytes.Buffer{}
+	small := resize(toRGBA(first), ThumbnailSize)
+
+	if contentType == "image/jpeg" {
+		out.ThumbnailExtension = "jpg"
+		err = jpeg.Encode(&thumb, small, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		out.ThumbnailExtension = "png"
+		err = png.Encode(&thumb, small)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out.Thumbnail = thumb.Bytes()
+
+	return out, nil
+}
+
+// ThumbnailType is the content type Process uses for thumbnails of the given
+// original.
+func ThumbnailType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+
+	return "image/png"
+}
+
+// checkGIFFrames walks the blocks of a GIF without decompressing anything and
+// rejects it when it has more than MaxFrames frames or more than MaxPixels
+// pixels across all of them. A few kilobytes of LZW data can otherwise
+// expand into gigabytes in gif.DecodeAll.
+func checkGIFFrames(data []byte) error {
+	// Header and logical screen descriptor.
+	if len(data) < 13 {
+		return ErrUnsupportedType
+	}
+
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+
+	frames, pixels := 0, 0
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x3B:
+			return nil
+
+		case 0x21:
+			i += 2
+
+		case 0x2C:
+			if i+10 > len(data) {
+				return ErrUnsupportedType
+			}
+
+			frames++
+			pixels += int(binary.LittleEndian.Uint16(data[i+5:])) * int(binary.LittleEndian.Uint16(data[i+7:]))
+
+			if frames > MaxFrames {
+				return ErrTooManyFrames
+			}
+
+			if pixels > MaxPixels {
+				return ErrTooManyPixels
+			}
+
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+
+			// LZW minimum code size.
+			i++
+
+		default:
+			return ErrUnsupportedType
+		}
+
+		// Data sub-blocks, ending with an empty one.
+		for i < len(data) && data[i] != 0 {
+			i += int(data[i]) + 1
+		}
+		i++
+	}
+
+	// Truncated files are left for the decoder to reject.
+	return nil
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	return rgba
+}
+
+// resize scales src down with a box filter so that neither side exceeds
+// maxSide. Images that already fit are returned as is.
+func resize(src *image.RGBA, maxSide int) *image.RGBA {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	if sw <= maxSide && sh <= maxSide {
+		return src
+	}
+
+	dw, dh := maxSide, sh*maxSide/sw
+	if sh > sw {
+		dw, dh = sw*maxSide/sh, maxSide
+	}
+	dw, dh = max(dw, 1), max(dh, 1)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := range dh {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+
+		for x := range dw {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+			var r, g, b, a, n int
+
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r, g, b, a = r+int(p[0]), g+int(p[1]), b+int(p[2]), a+int(p[3])
+					n++
+				}
+			}
+
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0], d[1], d[2], d[3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+
+	return dst
+}
+
+// orient applies an EXIF orientation (1-8) so the pixels display upright
+// without the tag.
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := range dh {
+		for x := range dw {
+			var sx, sy int
+
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], src.Pix[sy*src.Stride+sx*4:])
+		}
+	}
+
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation tag of a JPEG, or 1 when
+// there is none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+
+		i += 2 + length
+	}
+
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 0 || ifd+2 > len(tiff) {
+		return 1
+	}
+
+	count := int(order.Uint16(tiff[ifd:]))
+
+	for i := range count {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+
+	return 1
+}
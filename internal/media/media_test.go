@@ -0,0 +1,146 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func solidHalves(w, h int, left, right color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := range h {
+		for x := range w {
+			if x < w/2 {
+				img.SetRGBA(x, y, left)
+			} else {
+				img.SetRGBA(x, y, right)
+			}
+		}
+	}
+
+	return img
+}
+
+// withExif inserts an APP1 segment carrying an orientation tag and a fake
+// GPS string right after the SOI marker.
+func withExif(jpg []byte, orientation byte) []byte {
+	tiff := []byte{
+		'M', 'M', 0, 42, 0, 0, 0, 8,
+		0, 1,
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0,
+		0, 0, 0, 0,
+	}
+	tiff = append(tiff, []byte("GPS 51.5N 0.1W")...)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(payload) + 2
+	segment := append([]byte{0xFF, 0xE1, byte(length >> 8), byte(length)}, payload...)
+
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, segment...)
+	return append(out, jpg[2:]...)
+}
+
+func TestProcessJPEGStripsExifAndOrients(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+
+	buf := bytes.Buffer{}
+	jpeg.Encode(&buf, solidHalves(64, 32, red, blue), &jpeg.Options{Quality: 100})
+	data := withExif(buf.Bytes(), 6)
+
+	if jpegOrientation(data) != 6 {
+		t.Fatalf("Expected orientation 6, got %d", jpegOrientation(data))
+	}
+
+	img, err := Process(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.ContentType != "image/jpeg" || img.Width != 32 || img.Height != 64 {
+		t.Fatalf("Expected rotated 32x64 jpeg, got %s %dx%d", img.ContentType, img.Width, img.Height)
+	}
+
+	if bytes.Contains(img.Data, []byte("Exif")) || bytes.Contains(img.Data, []byte("GPS")) {
+		t.Fatal("Expected EXIF data to be stripped")
+	}
+
+	decoded, _ := jpeg.Decode(bytes.NewReader(img.Data))
+	r, _, b, _ := decoded.At(16, 8).RGBA()
+	if r < b {
+		t.Fatalf("Expected the left half to end up on top, got r=%d b=%d", r, b)
+	}
+}
+
+func TestProcessThumbnail(t *testing.T) {
+	buf := bytes.Buffer{}
+	png.Encode(&buf, solidHalves(1000, 500, color.RGBA{0, 0, 0, 255}, color.RGBA{255, 255, 255, 255}))
+
+	img, err := Process(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	thumb, err := png.DecodeConfig(bytes.NewReader(img.Thumbnail))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if thumb.Width != ThumbnailSize || thumb.Height != ThumbnailSize/2 {
+		t.Fatalf("Expected %dx%d thumbnail, got %dx%d", ThumbnailSize, ThumbnailSize/2, thumb.Width, thumb.Height)
+	}
+}
+
+func TestProcessRejectsNonImages(t *testing.T) {
+	_, err := Process([]byte("<html><body>not an image</body></html>"))
+	if err != ErrUnsupportedType {
+		t.Fatalf("Expected ErrUnsupportedType, got %v", err)
+	}
+}
+
+func TestProcessRejectsGIFBombs(t *testing.T) {
+	frame := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+
+	encode := func(frames int) []byte {
+		anim := &gif.GIF{}
+		for range frames {
+			anim.Image = append(anim.Image, frame)
+			anim.Delay = append(anim.Delay, 0)
+		}
+
+		buf := bytes.Buffer{}
+		err := gif.EncodeAll(&buf, anim)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return buf.Bytes()
+	}
+
+	_, err := Process(encode(3))
+	if err != nil {
+		t.Fatalf("Expected a small animation to pass, got %v", err)
+	}
+
+	_, err = Process(encode(MaxFrames + 1))
+	if err != ErrTooManyFrames {
+		t.Fatalf("Expected ErrTooManyFrames, got %v", err)
+	}
+
+	// A 4x4 canvas whose one frame claims to be 65535x65535 is rejected
+	// before any LZW data is read.
+	data := encode(1)
+	descriptor := bytes.IndexByte(data[13:], 0x2C) + 13
+	copy(data[descriptor+5:], []byte{0xFF, 0xFF, 0xFF, 0xFF})
+
+	_, err = Process(data)
+	if err != ErrTooManyPixels {
+		t.Fatalf("Expected ErrTooManyPixels, got %v", err)
+	}
+}
@@ -0,0 +1,417 @@
+// Package oidc signs users in with an external OpenID Connect provider using
+// the authorization code flow with PKCE (RFC 7636).
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/noueii/go-http-server/internal/auth"
+)
+
+const (
+	maxResponseBytes = 1 << 20
+	// minKeyRefresh limits how often an unknown kid makes us refetch the
+	// provider's keys.
+	minKeyRefresh = 10 * time.Second
+	idTokenLeeway = time.Minute
+	// requestTimeout bounds every call to the provider, so a slow one cannot
+	// hold up sign-ins indefinitely.
+	requestTimeout = 10 * time.Second
+)
+
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+// ErrInvalidIDToken is returned when the provider's ID token does not check
+// out, including a nonce that does not match the one we sent.
+var ErrInvalidIDToken = errors.New("oidc: invalid ID token")
+
+// TokenError is an error response from the token endpoint (RFC 6749 5.2),
+// such as invalid_grant for a code that was already used.
+type TokenError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *TokenError) Error() string {
+	if e.Description == "" {
+		return "oidc: token endpoint: " + e.Code
+	}
+
+	return "oidc: token endpoint: " + e.Code + ": " + e.Description
+}
+
+type Config struct {
+	// Name identifies the provider in routes and stored identities.
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Metadata is the part of the provider's discovery document we use.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Identity is the user the provider vouched for in a verified ID token.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider talks to one OpenID Connect provider. Its discovery document is
+// fetched on first use, and its signing keys again whenever a token names a
+// key we have not seen. Nothing is fetched while mu is held.
+type Provider struct {
+	Config Config
+	Client *http.Client
+
+	now         func() time.Time
+	mu          sync.Mutex
+	metadata    *Metadata
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+func NewProvider(config Config) *Provider {
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = DefaultScopes
+	}
+
+	// Without openid the provider returns no ID token.
+	if !slices.Contains(config.Scopes, "openid") {
+		config.Scopes = append([]string{"openid"}, config.Scopes...)
+	}
+
+	return &Provider{
+		Config: config,
+		Client: &http.Client{Timeout: requestTimeout},
+		now:    time.Now,
+	}
+}
+
+// NewVerifier returns a PKCE code verifier: 32 random bytes, base64url.
+func NewVerifier() (string, error) {
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// Challenge is the S256 code challenge for a verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL is where to send the user to sign in. The provider redirects
+// back to Config.RedirectURL with state and a code for Authenticate.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.Config.ClientID},
+		"redirect_uri":          {p.Config.RedirectURL},
+		"scope":                 {strings.Join(p.Config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Authenticate redeems an authorization code and returns the identity in the
+// ID token that comes back, once its signature, issuer, audience, expiry and
+// nonce have been checked.
+func (p *Provider) Authenticate(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	idToken, err := p.exchange(ctx, code, verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.VerifyIDToken(ctx, idToken, nonce)
+}
+
+func (p *Provider) exchange(ctx context.Context, code, verifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.Config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+
+	// Confidential clients authenticate with client_secret_basic, the
+	// default every provider supports. Public clients only name themselves.
+	if p.Config.ClientSecret == "" {
+		form.Set("client_id", p.Config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseBytes))
+	if err != nil {
+		return "", err
+	}
+
+	if res.StatusCode != 200 {
+		tokenErr := &TokenError{}
+		if json.Unmarshal(body, tokenErr) == nil && tokenErr.Code != "" {
+			return "", tokenErr
+		}
+
+		return "", fmt.Errorf("oidc: token endpoint: %s", res.Status)
+	}
+
+	tokens := struct {
+		IDToken string `json:"id_token"`
+	}{}
+
+	err = json.Unmarshal(body, &tokens)
+	if err != nil {
+		return "", fmt.Errorf("oidc: token endpoint: %w", err)
+	}
+
+	if tokens.IDToken == "" {
+		return "", fmt.Errorf("%w: token response has no id_token", ErrInvalidIDToken)
+	}
+
+	return tokens.IDToken, nil
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce           string `json:"nonce"`
+	AuthorizedParty string `json:"azp"`
+	Email           string `json:"email"`
+	EmailVerified   bool   `json:"email_verified"`
+	Name            string `json:"name"`
+}
+
+// VerifyIDToken checks an ID token issued to us and returns its identity.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*Identity, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &idTokenClaims{}
+
+	_, err = jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(metadata.Issuer),
+		jwt.WithAudience(p.Config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(idTokenLeeway),
+		jwt.WithTimeFunc(p.now),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	// With several audiences the token must say it was issued to us.
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.Config.ClientID {
+		return nil, fmt.Errorf("%w: azp %q", ErrInvalidIDToken, claims.AuthorizedParty)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing sub", ErrInvalidIDToken)
+	}
+
+	return &Identity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}, nil
+}
+
+func (p *Provider) discover(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	metadata := p.metadata
+	p.mu.Unlock()
+
+	if metadata != nil {
+		return metadata, nil
+	}
+
+	metadata = &Metadata{}
+
+	err := p.getJSON(ctx, p.Config.Issuer+"/.well-known/openid-configuration", metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.Config.Issuer {
+		return nil, fmt.Errorf("oidc: discovery document is for issuer %q, want %q", metadata.Issuer, p.Config.Issuer)
+	}
+
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: discovery document for %q is missing endpoints", p.Config.Issuer)
+	}
+
+	// Concurrent first requests may each fetch the document; the first to
+	// finish is kept.
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata == nil {
+		p.metadata = metadata
+	}
+
+	return p.metadata, nil
+}
+
+// key finds the provider's signing key by kid. A token without a kid is
+// accepted only while the provider publishes a single key. The caller that
+// finds a key missing claims the refetch, so concurrent ones do not pile on
+// the provider; they fail and the user retries.
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+
+	key := findKey(p.keys, kid)
+	refresh := key == nil && p.now().Sub(p.keysFetched) >= minKeyRefresh
+
+	if refresh {
+		p.keysFetched = p.now()
+	}
+
+	jwksURI := p.metadata.JWKSURI
+	p.mu.Unlock()
+
+	if refresh {
+		set := auth.JWKS{}
+
+		err := p.getJSON(ctx, jwksURI, &set)
+		if err != nil {
+			return nil, err
+		}
+
+		keys := map[string]crypto.PublicKey{}
+
+		for _, jwk := range set.Keys {
+			if jwk.Use != "" && jwk.Use != "sig" {
+				continue
+			}
+
+			public, err := jwk.PublicKey()
+			if err != nil {
+				continue
+			}
+
+			keys[jwk.Kid] = public
+		}
+
+		p.mu.Lock()
+		p.keys = keys
+		p.mu.Unlock()
+
+		key = findKey(keys, kid)
+	}
+
+	if key == nil {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+func findKey(keys map[string]crypto.PublicKey, kid string) crypto.PublicKey {
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key
+		}
+	}
+
+	return keys[kid]
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return fmt.Errorf("oidc: GET %s: %s", url, res.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, maxResponseBytes)).Decode(v)
+}
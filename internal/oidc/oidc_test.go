@@ -0,0 +1,399 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/noueii/go-http-server/internal/auth"
+)
+
+// mockProvider is a minimal OpenID Connect provider. Its authorization
+// endpoint signs in subject straight away and its token endpoint enforces
+// PKCE and client authentication.
+type mockProvider struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	kid     string
+	key     *ecdsa.PrivateKey
+	subject string
+	email   string
+	codes   map[string]mockCode
+	// audience overrides the aud claim of issued ID tokens.
+	audience string
+	// jwksCalled and jwksRelease, when set, hold the JWKS endpoint until the
+	// test lets it answer.
+	jwksCalled  chan struct{}
+	jwksRelease chan struct{}
+}
+
+type mockCode struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+}
+
+const (
+	mockClientID     = "chirpy-client"
+	mockClientSecret = "s3cret/+"
+)
+
+func newMockProvider(t *testing.T) *mockProvider {
+	m := &mockProvider{subject: "user-123", email: "alice@example.com", codes: map[string]mockCode{}}
+	m.rotate(t)
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(rw http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(rw).Encode(Metadata{
+			Issuer:                m.URL,
+			AuthorizationEndpoint: m.URL + "/authorize",
+			TokenEndpoint:         m.URL + "/token",
+			JWKSURI:               m.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("GET /jwks", func(rw http.ResponseWriter, req *http.Request) {
+		if m.jwksRelease != nil {
+			m.jwksCalled <- struct{}{}
+			<-m.jwksRelease
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		point, _ := m.key.PublicKey.ECDH()
+		raw := point.Bytes()
+		b64 := base64.RawURLEncoding.EncodeToString
+
+		json.NewEncoder(rw).Encode(auth.JWKS{Keys: []auth.JWK{{
+			Kty: "EC", Use: "sig", Alg: "ES256", Kid: m.kid, Crv: "P-256", X: b64(raw[1:33]), Y: b64(raw[33:]),
+		}}})
+	})
+
+	mux.HandleFunc("GET /authorize", func(rw http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+
+		if query.Get("client_id") != mockClientID || query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+			http.Error(rw, "bad request", 400)
+			return
+		}
+
+		m.mu.Lock()
+		code := rand.Text()
+		m.codes[code] = mockCode{
+			challenge:   query.Get("code_challenge"),
+			nonce:       query.Get("nonce"),
+			redirectURI: query.Get("redirect_uri"),
+		}
+		m.mu.Unlock()
+
+		http.Redirect(rw, req, query.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {query.Get("state")}}.Encode(), 302)
+	})
+
+	mux.HandleFunc("POST /token", func(rw http.ResponseWriter, req *http.Request) {
+		id, secret, _ := req.BasicAuth()
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+
+		if id != mockClientID || secret != mockClientSecret {
+			rw.WriteHeader(401)
+			json.NewEncoder(rw).Encode(TokenError{Code: "invalid_client"})
+			return
+		}
+
+		m.mu.Lock()
+		code, ok := m.codes[req.FormValue("code")]
+		delete(m.codes, req.FormValue("code"))
+		m.mu.Unlock()
+
+		if !ok || req.FormValue("grant_type") != "authorization_code" || req.FormValue("redirect_uri") != code.redirectURI || Challenge(req.FormValue("code_verifier")) != code.challenge {
+			rw.WriteHeader(400)
+			json.NewEncoder(rw).Encode(TokenError{Code: "invalid_grant"})
+			return
+		}
+
+		json.NewEncoder(rw).Encode(map[string]string{
+			"access_token": "opaque",
+			"token_type":   "Bearer",
+			"id_token":     m.idToken(t, code.nonce),
+		})
+	})
+
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+
+	return m
+}
+
+func (m *mockProvider) rotate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.mu.Lock()
+	m.key = key
+	m.kid = rand.Text()
+	m.mu.Unlock()
+}
+
+func (m *mockProvider) idToken(t *testing.T, nonce string) string {
+	audience := m.audience
+	if audience == "" {
+		audience = mockClientID
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, idTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.URL,
+			Subject:   m.subject,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+		Nonce:         nonce,
+		Email:         m.email,
+		EmailVerified: true,
+	})
+	token.Header["kid"] = m.kid
+
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+// signIn runs the browser's half of the flow: it follows the authorization
+// URL and returns the code and state the provider redirects back with.
+func signIn(t *testing.T, p *Provider, state, nonce, verifier string) (string, string) {
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != 302 {
+		t.Fatalf("authorize returned %d", res.StatusCode)
+	}
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func newTestProvider(m *mockProvider) *Provider {
+	return NewProvider(Config{
+		Name:         "mock",
+		Issuer:       m.URL,
+		ClientID:     mockClientID,
+		ClientSecret: mockClientSecret,
+		RedirectURL:  "http://chirpy.test/api/auth/mock/callback",
+	})
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(m)
+
+	verifier, err := NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, state := signIn(t, p, "the-state", "the-nonce", verifier)
+	if state != "the-state" {
+		t.Fatalf("got state %q", state)
+	}
+
+	identity, err := p.Authenticate(context.Background(), code, verifier, "the-nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if identity.Subject != "user-123" || identity.Email != "alice@example.com" || !identity.EmailVerified {
+		t.Fatalf("unexpected identity %+v", identity)
+	}
+
+	// Codes are single use.
+	_, err = p.Authenticate(context.Background(), code, verifier, "the-nonce")
+
+	tokenErr := &TokenError{}
+	if !errors.As(err, &tokenErr) || tokenErr.Code != "invalid_grant" {
+		t.Fatalf("got %v, want invalid_grant", err)
+	}
+}
+
+func TestAuthorizationCodeFlowRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(m *mockProvider, p *Provider)
+		nonce    string
+		verifier func(string) string
+		want     error
+	}{
+		{
+			name:     "wrong verifier",
+			verifier: func(string) string { return "not-the-verifier" },
+			want:     &TokenError{},
+		},
+		{
+			name:  "wrong nonce",
+			nonce: "another-nonce",
+			want:  ErrInvalidIDToken,
+		},
+		{
+			name:  "other audience",
+			setup: func(m *mockProvider, p *Provider) { m.audience = "someone-else" },
+			want:  ErrInvalidIDToken,
+		},
+		{
+			name:  "wrong client secret",
+			setup: func(m *mockProvider, p *Provider) { p.Config.ClientSecret = "guess" },
+			want:  &TokenError{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			p := newTestProvider(m)
+
+			if tt.setup != nil {
+				tt.setup(m, p)
+			}
+
+			verifier, err := NewVerifier()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			code, _ := signIn(t, p, "state", "nonce", verifier)
+
+			if tt.verifier != nil {
+				verifier = tt.verifier(verifier)
+			}
+
+			nonce := "nonce"
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			_, err = p.Authenticate(context.Background(), code, verifier, nonce)
+
+			tokenErr := &TokenError{}
+			if _, ok := tt.want.(*TokenError); ok {
+				if !errors.As(err, &tokenErr) {
+					t.Fatalf("got %v, want a token error", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(m)
+
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	_, err := p.VerifyIDToken(context.Background(), m.idToken(t, "n"), "n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.rotate(t)
+	rotated := m.idToken(t, "n")
+
+	// The new kid is not fetched again straight away.
+	_, err = p.VerifyIDToken(context.Background(), rotated, "n")
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("got %v, want ErrInvalidIDToken", err)
+	}
+
+	now = now.Add(minKeyRefresh)
+
+	_, err = p.VerifyIDToken(context.Background(), rotated, "n")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSlowKeyFetch(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(m)
+
+	m.jwksCalled = make(chan struct{})
+	m.jwksRelease = make(chan struct{})
+	token := m.idToken(t, "n")
+
+	done := make(chan error)
+	go func() {
+		_, err := p.VerifyIDToken(context.Background(), token, "n")
+		done <- err
+	}()
+
+	<-m.jwksCalled
+
+	// Other sign-ins go ahead while the keys are being fetched.
+	started := make(chan error)
+	go func() {
+		_, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+		started <- err
+	}()
+
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("AuthCodeURL waited for the key fetch")
+	}
+
+	close(m.jwksRelease)
+
+	err := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	// RFC 7636 Appendix B.
+	got := Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,356 @@
+package qr
+
+import (
+	"bytes"
+	"image/png"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" as 1-M from the worked example on thonky.com.
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+	got := reedSolomonRemainder(data, reedSolomonDivisor(len(want)))
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	for version, want := range map[int]int{1: 16, 5: 86, 10: 216, 40: 2334} {
+		if got := numDataCodewords(version); got != want {
+			t.Errorf("version %d: got %d data codewords, want %d", version, got, want)
+		}
+	}
+}
+
+func TestAlignmentPositions(t *testing.T) {
+	for version, want := range map[int][]int{
+		1:  nil,
+		2:  {6, 18},
+		7:  {6, 22, 38},
+		32: {6, 34, 60, 86, 112, 138},
+		40: {6, 30, 58, 86, 114, 142, 170},
+	} {
+		if got := alignmentPositions(version); !slices.Equal(got, want) {
+			t.Errorf("version %d: got %v, want %v", version, got, want)
+		}
+	}
+}
+
+func TestFormatAndVersionBits(t *testing.T) {
+	c := &Code{Size: 7*4 + 17, Version: 7}
+	c.modules = grid(c.Size)
+	c.isFunction = grid(c.Size)
+	c.drawFunctionPatterns()
+
+	// Level M, mask 0, read from the top left copy.
+	format := ""
+	for i := 14; i >= 9; i-- {
+		format += bit(c.Dark(14-i, 8))
+	}
+	format += bit(c.Dark(7, 8)) + bit(c.Dark(8, 8)) + bit(c.Dark(8, 7))
+	for i := 5; i >= 0; i-- {
+		format += bit(c.Dark(8, i))
+	}
+	if format != "101010000010010" {
+		t.Errorf("got format bits %s", format)
+	}
+
+	version := ""
+	for i := 17; i >= 0; i-- {
+		version += bit(c.Dark(c.Size-11+i%3, i/3))
+	}
+	if version != "000111110010010100" {
+		t.Errorf("got version bits %s", version)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	uri := "otpauth://totp/Chirpy:walt%40breakingbad.com?secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP&issuer=Chirpy"
+
+	c, err := Encode([]byte(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Version != 6 || c.Size != 41 {
+		t.Fatalf("got version %d size %d, want version 6", c.Version, c.Size)
+	}
+
+	// The dark module next to the bottom left finder is always set.
+	if !c.Dark(8, c.Size-8) {
+		t.Fatal("dark module missing")
+	}
+
+	data, err := c.PNG(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != (41+8)*4 {
+		t.Fatalf("got width %d", img.Bounds().Dx())
+	}
+
+	_, err = Encode([]byte(strings.Repeat("a", 3000)))
+	if err != ErrTooLong {
+		t.Fatalf("got %v, want ErrTooLong", err)
+	}
+}
+
+func bit(dark bool) string {
+	if dark {
+		return "1"
+	}
+	return "0"
+}
+
+func TestRoundTrip(t *testing.T) {
+	inputs := []string{
+		"A",
+		"otpauth://totp/Chirpy:walt%40breakingbad.com?secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP&issuer=Chirpy",
+		strings.Repeat("0123456789abcdef", 10),
+		strings.Repeat("The quick brown fox jumps over the lazy dog. ", 12),
+	}
+
+	for _, input := range inputs {
+		c, err := Encode([]byte(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := decode(t, c); got != input {
+			t.Errorf("version %d: decoded %q, want %q", c.Version, got, input)
+		}
+	}
+}
+
+// decode reads a code back the way a scanner would. It finds the data
+// modules, undoes the mask and interleaving and checks every block's
+// Reed-Solomon syndromes without using any of the encoder's layout code.
+func decode(t *testing.T, c *Code) string {
+	t.Helper()
+
+	size := c.Size
+	version := (size - 17) / 4
+
+	dark := func(row, col int) int {
+		if c.Dark(col, row) {
+			return 1
+		}
+		return 0
+	}
+
+	// Both copies of the format information must agree and be a valid BCH
+	// codeword for level M.
+	format, copy2 := 0, 0
+	for i := range 6 {
+		format |= dark(i, 8) << i
+	}
+	format |= dark(7, 8)<<6 | dark(8, 8)<<7 | dark(8, 7)<<8
+	for i := 9; i < 15; i++ {
+		format |= dark(8, 14-i) << i
+	}
+	for i := range 8 {
+		copy2 |= dark(8, size-1-i) << i
+	}
+	for i := 8; i < 15; i++ {
+		copy2 |= dark(size-15+i, 8) << i
+	}
+
+	if format != copy2 {
+		t.Fatalf("version %d: format copies differ: %015b and %015b", version, format, copy2)
+	}
+
+	format ^= 0x5412
+	rem := format
+	for i := 14; i >= 10; i-- {
+		if rem>>i&1 == 1 {
+			rem ^= 0x537 << (i - 10)
+		}
+	}
+	if rem != 0 || format>>13 != 0 {
+		t.Fatalf("version %d: invalid format bits %015b", version, format)
+	}
+	mask := format >> 10 & 7
+
+	// Everything that is not a data module.
+	reserved := make([][]bool, size)
+	for i := range reserved {
+		reserved[i] = make([]bool, size)
+	}
+	reserve := func(row, col, rows, cols int) {
+		for r := row; r < row+rows; r++ {
+			for c := col; c < col+cols; c++ {
+				reserved[r][c] = true
+			}
+		}
+	}
+
+	// Alignment patterns go everywhere on the grid except over the finders.
+	for _, row := range alignmentPositions(version) {
+		for _, col := range alignmentPositions(version) {
+			if (row < 9 && col < 9) || (row < 9 && col >= size-8) || (row >= size-8 && col < 9) {
+				continue
+			}
+			reserve(row-2, col-2, 5, 5)
+		}
+	}
+
+	reserve(0, 0, 9, 9)
+	reserve(0, size-8, 9, 8)
+	reserve(size-8, 0, 8, 9)
+	reserve(6, 0, 1, size)
+	reserve(0, 6, size, 1)
+
+	if version >= 7 {
+		reserve(0, size-11, 6, 3)
+		reserve(size-11, 0, 3, 6)
+	}
+
+	// Data is read in two-column strips from the bottom right, alternating
+	// upwards and downwards and skipping the vertical timing pattern.
+	bits := []byte{}
+	upward := true
+
+	for col := size - 1; col > 0; col -= 2 {
+		if col == 6 {
+			col--
+		}
+
+		for i := range size {
+			row := i
+			if upward {
+				row = size - 1 - i
+			}
+
+			for _, x := range []int{col, col - 1} {
+				if reserved[row][x] {
+					continue
+				}
+
+				bit := dark(row, x)
+				if maskCondition(mask, row, x) {
+					bit ^= 1
+				}
+				bits = append(bits, byte(bit))
+			}
+		}
+
+		upward = !upward
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i := range codewords {
+		for _, bit := range bits[i*8 : i*8+8] {
+			codewords[i] = codewords[i]<<1 | bit
+		}
+	}
+
+	// De-interleave into blocks: data codewords first, one from each block
+	// in turn, then the error correction codewords the same way.
+	blocks := numBlocks[version]
+	ecc := eccCodewordsPerBlock[version]
+	short := blocks - len(codewords)%blocks
+	shortData := len(codewords)/blocks - ecc
+
+	data := make([][]byte, blocks)
+	parity := make([][]byte, blocks)
+	next := 0
+
+	for i := range shortData + 1 {
+		for b := range blocks {
+			if i < shortData || b >= short {
+				data[b] = append(data[b], codewords[next])
+				next++
+			}
+		}
+	}
+	for range ecc {
+		for b := range blocks {
+			parity[b] = append(parity[b], codewords[next])
+			next++
+		}
+	}
+
+	payload := []byte{}
+
+	for b := range blocks {
+		block := append(append([]byte{}, data[b]...), parity[b]...)
+
+		// A valid block evaluates to zero at the first ecc powers of the
+		// generator, 2.
+		alpha := byte(1)
+		for i := range ecc {
+			var syndrome byte
+			for _, codeword := range block {
+				syndrome = gfMultiply(syndrome, alpha) ^ codeword
+			}
+			if syndrome != 0 {
+				t.Fatalf("version %d: block %d syndrome %d is %d", version, b, i, syndrome)
+			}
+			alpha = gfMultiply(alpha, 2)
+		}
+
+		payload = append(payload, data[b]...)
+	}
+
+	read := func(n int) int {
+		v := 0
+		for range n {
+			v = v<<1 | int(payload[0]>>7)
+			payload[0] <<= 1
+			if next++; next%8 == 0 {
+				payload = payload[1:]
+			}
+		}
+		return v
+	}
+	next = 0
+
+	if mode := read(4); mode != 0b0100 {
+		t.Fatalf("version %d: got mode %04b, want byte mode", version, mode)
+	}
+
+	length := read(8)
+	if version >= 10 {
+		length = length<<8 | read(8)
+	}
+
+	out := make([]byte, length)
+	for i := range out {
+		out[i] = byte(read(8))
+	}
+
+	return string(out)
+}
+
+// maskCondition is the mask pattern table from the standard, in terms of
+// row i and column j.
+func maskCondition(mask, i, j int) bool {
+	switch mask {
+	case 0:
+		return (i+j)%2 == 0
+	case 1:
+		return i%2 == 0
+	case 2:
+		return j%3 == 0
+	case 3:
+		return (i+j)%3 == 0
+	case 4:
+		return (i/2+j/3)%2 == 0
+	case 5:
+		return i*j%2+i*j%3 == 0
+	case 6:
+		return (i*j%2+i*j%3)%2 == 0
+	default:
+		return ((i+j)%2+i*j%3)%2 == 0
+	}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/noueii/go-http-server/internal/app"
@@ -33,8 +38,31 @@ func main() {
 		Handler: app.Api.ServeMux,
 	}
 
-	server.ListenAndServe()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Server stopped: %v", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Handlers that outlive the timeout could still enqueue fan-out jobs, so
+	// the workers are only stopped after a clean shutdown.
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Printf("Could not shut down cleanly: %v", err)
+		return
+	}
+
+	app.Close()
 }
 
 func handlerHealth(rw http.ResponseWriter, req *http.Request) {
@@ -0,0 +1,16 @@
+-- name: UpdateChirpBody :one
+WITH revision AS (
+	INSERT INTO chirp_revisions(id, created_at, chirp_id, body)
+	SELECT gen_random_uuid(), NOW(), chirps.id, chirps.body
+	FROM chirps
+	WHERE chirps.id = $1 AND chirps.user_id = $2 AND chirps.deleted_at IS NULL
+)
+UPDATE chirps
+SET body = $3, updated_at = NOW(), edited = true
+WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+RETURNING *;
+
+-- name: GetChirpRevisionsByChirpId :many
+SELECT * FROM chirp_revisions
+WHERE chirp_id = $1
+ORDER BY created_at DESC, id DESC;
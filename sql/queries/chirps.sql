@@ -1,22 +1,112 @@
 -- name: CreateChirp :one
-INSERT INTO chirps(id, created_at, updated_at, body, user_id)
+INSERT INTO chirps(id, created_at, updated_at, body, user_id, parent_id, root_id)
 VALUES (
 	gen_random_uuid(),
 	NOW(),
 	NOW(),
 	$1,
-	$2
+	$2,
+	$3,
+	$4
 )
 RETURNING *;
 
--- name: GetAllChirps :many
-SELECT * FROM chirps ORDER BY created_at ASC;
+-- name: GetChirpsPageAsc :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+AND (sqlc.narg('parent_id')::uuid IS NULL OR parent_id = sqlc.narg('parent_id')::uuid)
+AND (
+	sqlc.narg('cursor_created_at')::timestamp IS NULL
+	OR (created_at, id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('page_size');
+
+-- name: GetChirpsPageDesc :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+AND (sqlc.narg('parent_id')::uuid IS NULL OR parent_id = sqlc.narg('parent_id')::uuid)
+AND (
+	sqlc.narg('cursor_created_at')::timestamp IS NULL
+	OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_size');
 
 -- name: GetChirpById :one
 SELECT * FROM chirps WHERE id = $1;
 
+-- name: LockChirpById :one
+SELECT * FROM chirps WHERE id = $1 FOR UPDATE;
+
 -- name: DeleteChirpById :exec
 DELETE FROM chirps WHERE id = $1;
 
--- name: GetAllChirpsByAuthorId :many
-SELECT * FROM chirps WHERE user_id = $1 ORDER BY created_at ASC;
+-- name: DeleteChirpWithoutReplies :execrows
+DELETE FROM chirps
+WHERE id = $1
+AND NOT EXISTS (SELECT 1 FROM chirps AS replies WHERE replies.parent_id = $1);
+
+-- name: TombstoneChirpById :exec
+WITH removed_revisions AS (
+	DELETE FROM chirp_revisions WHERE chirp_id = $1
+), removed_hashtags AS (
+	DELETE FROM chirp_hashtags WHERE chirp_id = $1
+), removed_mentions AS (
+	DELETE FROM mentions WHERE chirp_id = $1
+), removed_media AS (
+	DELETE FROM media WHERE chirp_id = $1
+)
+UPDATE chirps
+SET body = '', edited = false, deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors(id, parent_id) AS (
+	SELECT parent.id, parent.parent_id
+	FROM chirps AS parent
+	WHERE parent.id = (SELECT child.parent_id FROM chirps AS child WHERE child.id = $1)
+	UNION ALL
+	SELECT parent.id, parent.parent_id
+	FROM chirps AS parent
+	JOIN ancestors ON parent.id = ancestors.parent_id
+)
+SELECT chirps.* FROM chirps
+WHERE chirps.id IN (SELECT ancestors.id FROM ancestors)
+ORDER BY chirps.created_at ASC, chirps.id ASC;
+
+-- name: GetChirpDescendants :many
+WITH RECURSIVE descendants(id) AS (
+	SELECT reply.id
+	FROM chirps AS reply
+	WHERE reply.parent_id = $1
+	UNION ALL
+	SELECT reply.id
+	FROM chirps AS reply
+	JOIN descendants ON reply.parent_id = descendants.id
+)
+SELECT chirps.* FROM chirps
+WHERE chirps.id IN (SELECT descendants.id FROM descendants)
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT $2;
+
+-- name: SearchChirps :many
+SELECT
+	chirps.*,
+	ts_rank_cd(to_tsvector('english', body), search_query)::real AS rank,
+	ts_headline(
+		'english',
+		replace(replace(replace(replace(replace(body, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;'),
+		search_query,
+		'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'
+	)::text AS headline
+FROM chirps, to_tsquery('english', sqlc.arg('query')::text) search_query
+WHERE to_tsvector('english', body) @@ search_query
+AND deleted_at IS NULL
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id')::uuid)
+AND (sqlc.narg('since')::timestamp IS NULL OR created_at >= sqlc.narg('since')::timestamp)
+AND (sqlc.narg('until')::timestamp IS NULL OR created_at < sqlc.narg('until')::timestamp)
+ORDER BY rank DESC, created_at DESC, id DESC
+LIMIT sqlc.arg('page_size') OFFSET sqlc.arg('page_offset');
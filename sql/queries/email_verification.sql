@@ -0,0 +1,29 @@
+-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens(token_hash, created_at, expires_at, user_id, email)
+VALUES (
+	$1,
+	NOW(),
+	$2,
+	$3,
+	$4
+);
+
+-- name: GetLatestEmailVerificationToken :one
+SELECT * FROM email_verification_tokens
+WHERE user_id = $1
+ORDER BY created_at DESC
+LIMIT 1;
+
+-- name: DeleteEmailVerificationTokensByUserId :exec
+DELETE FROM email_verification_tokens WHERE user_id = $1;
+
+-- name: ConsumeEmailVerificationToken :one
+DELETE FROM email_verification_tokens
+WHERE token_hash = sqlc.arg('token_hash') AND expires_at > sqlc.arg('now')
+RETURNING *;
+
+-- name: MarkUserEmailVerified :one
+UPDATE users
+SET email_verified_at = NOW(), updated_at = NOW()
+WHERE id = $1 AND email = $2
+RETURNING *;
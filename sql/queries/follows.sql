@@ -0,0 +1,36 @@
+-- name: CreateFollow :execrows
+INSERT INTO follows(id, created_at, follower_id, followee_id)
+VALUES (
+	gen_random_uuid(),
+	NOW(),
+	$1,
+	$2
+)
+ON CONFLICT (follower_id, followee_id) DO NOTHING;
+
+-- name: DeleteFollow :execrows
+DELETE FROM follows WHERE follower_id = $1 AND followee_id = $2;
+
+-- name: GetFollowersPage :many
+SELECT users.*, follows.id AS follow_id, follows.created_at AS followed_at
+FROM follows
+JOIN users ON users.id = follows.follower_id
+WHERE follows.followee_id = sqlc.arg('user_id')
+AND (
+	sqlc.narg('cursor_created_at')::timestamp IS NULL
+	OR (follows.created_at, follows.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY follows.created_at DESC, follows.id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: GetFollowingPage :many
+SELECT users.*, follows.id AS follow_id, follows.created_at AS followed_at
+FROM follows
+JOIN users ON users.id = follows.followee_id
+WHERE follows.follower_id = sqlc.arg('user_id')
+AND (
+	sqlc.narg('cursor_created_at')::timestamp IS NULL
+	OR (follows.created_at, follows.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY follows.created_at DESC, follows.id DESC
+LIMIT sqlc.arg('page_size');
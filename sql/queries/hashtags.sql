@@ -0,0 +1,40 @@
+-- name: UpsertHashtags :exec
+INSERT INTO hashtags(id, created_at, tag)
+SELECT gen_random_uuid(), NOW(), new_tags.tag
+FROM unnest(sqlc.arg('tags')::text[]) AS new_tags(tag)
+ON CONFLICT (tag) DO NOTHING;
+
+-- name: AttachHashtagsToChirp :exec
+INSERT INTO chirp_hashtags(chirp_id, hashtag_id, created_at)
+SELECT sqlc.arg('chirp_id')::uuid, hashtags.id, sqlc.arg('created_at')::timestamp
+FROM hashtags
+WHERE hashtags.tag = ANY(sqlc.arg('tags')::text[])
+ON CONFLICT DO NOTHING;
+
+-- name: DetachHashtagsFromChirp :exec
+DELETE FROM chirp_hashtags WHERE chirp_id = $1;
+
+-- name: GetHashtagChirpsPage :many
+SELECT chirps.* FROM chirp_hashtags
+JOIN hashtags ON hashtags.id = chirp_hashtags.hashtag_id
+JOIN chirps ON chirps.id = chirp_hashtags.chirp_id
+WHERE hashtags.tag = sqlc.arg('tag')
+AND chirps.deleted_at IS NULL
+AND (
+	sqlc.narg('cursor_created_at')::timestamp IS NULL
+	OR (chirp_hashtags.created_at, chirp_hashtags.chirp_id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY chirp_hashtags.created_at DESC, chirp_hashtags.chirp_id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: GetTrendingHashtags :many
+SELECT
+	hashtags.tag,
+	COUNT(*)::int AS uses,
+	SUM(EXP(-LN(2) * EXTRACT(EPOCH FROM (NOW() - chirp_hashtags.created_at)) / sqlc.arg('half_life_seconds')::float8))::float8 AS score
+FROM chirp_hashtags
+JOIN hashtags ON hashtags.id = chirp_hashtags.hashtag_id
+WHERE chirp_hashtags.created_at >= NOW() - make_interval(secs => sqlc.arg('window_seconds')::float8)
+GROUP BY hashtags.id, hashtags.tag
+ORDER BY score DESC, hashtags.tag ASC
+LIMIT sqlc.arg('result_limit');
@@ -0,0 +1,29 @@
+-- name: CreateLike :execrows
+INSERT INTO likes(id, created_at, user_id, chirp_id)
+VALUES (
+	gen_random_uuid(),
+	NOW(),
+	$1,
+	$2
+)
+ON CONFLICT (user_id, chirp_id) DO NOTHING;
+
+-- name: DeleteLike :execrows
+DELETE FROM likes WHERE user_id = $1 AND chirp_id = $2;
+
+-- name: GetLikedChirpIds :many
+SELECT chirp_id FROM likes
+WHERE user_id = sqlc.arg('user_id') AND chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[]);
+
+-- name: GetLikedChirpsPage :many
+SELECT chirps.*, likes.id AS like_id, likes.created_at AS liked_at
+FROM likes
+JOIN chirps ON chirps.id = likes.chirp_id
+WHERE likes.user_id = sqlc.arg('user_id')
+AND chirps.deleted_at IS NULL
+AND (
+	sqlc.narg('cursor_created_at')::timestamp IS NULL
+	OR (likes.created_at, likes.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY likes.created_at DESC, likes.id DESC
+LIMIT sqlc.arg('page_size');
@@ -0,0 +1,35 @@
+-- name: LockLoginThrottle :one
+INSERT INTO login_throttles(scope, key, failures, last_failure_at)
+VALUES (
+	$1,
+	$2,
+	0,
+	NOW()
+)
+ON CONFLICT (scope, key) DO UPDATE
+SET scope = EXCLUDED.scope
+RETURNING *;
+
+-- name: UpdateLoginThrottle :exec
+UPDATE login_throttles
+SET failures = $3, last_failure_at = $4, blocked_until = $5
+WHERE scope = $1 AND key = $2;
+
+-- name: RefundLoginAttempt :exec
+UPDATE login_throttles
+SET failures = GREATEST(failures - 1, 0),
+	blocked_until = CASE WHEN failures - 1 < sqlc.arg('free_attempts')::int THEN NULL ELSE blocked_until END
+WHERE scope = sqlc.arg('scope') AND key = sqlc.arg('key');
+
+-- name: UncountLoginAttempt :exec
+UPDATE login_throttles
+SET failures = failures - 1, blocked_until = NULL
+WHERE scope = $1 AND key = $2 AND failures = $3;
+
+-- name: ClearLoginThrottle :exec
+DELETE FROM login_throttles WHERE scope = $1 AND key = $2;
+
+-- name: DeleteStaleLoginThrottles :exec
+DELETE FROM login_throttles
+WHERE last_failure_at < $1
+AND (blocked_until IS NULL OR blocked_until < $1);
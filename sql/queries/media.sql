@@ -0,0 +1,35 @@
+-- name: CreateMedia :one
+INSERT INTO media(id, created_at, user_id, content_type, size_bytes, width, height, alt_text, storage_key, thumbnail_key)
+VALUES (
+	$1,
+	NOW(),
+	$2,
+	$3,
+	$4,
+	$5,
+	$6,
+	$7,
+	$8,
+	$9
+)
+RETURNING *;
+
+-- name: GetMediaById :one
+SELECT * FROM media WHERE id = $1;
+
+-- name: AttachMediaToChirp :execrows
+UPDATE media
+SET chirp_id = sqlc.arg('chirp_id'), position = attached.position
+FROM unnest(sqlc.arg('ids')::uuid[]) WITH ORDINALITY AS attached(id, position)
+WHERE media.id = attached.id
+AND media.user_id = sqlc.arg('user_id')
+AND media.chirp_id IS NULL;
+
+-- name: GetMediaByChirpIds :many
+SELECT * FROM media
+WHERE chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[])
+ORDER BY chirp_id, position;
+
+-- name: DeleteMediaByChirpId :many
+DELETE FROM media WHERE chirp_id = $1
+RETURNING *;
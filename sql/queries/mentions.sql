@@ -0,0 +1,23 @@
+-- name: GetUsersByHandles :many
+SELECT id, handle FROM users
+WHERE handle = ANY(sqlc.arg('handles')::text[]);
+
+-- name: CreateMentions :exec
+INSERT INTO mentions(chirp_id, user_id, start_offset, end_offset)
+SELECT sqlc.arg('chirp_id')::uuid, new_mentions.user_id, new_mentions.start_offset, new_mentions.end_offset
+FROM unnest(
+	sqlc.arg('user_ids')::uuid[],
+	sqlc.arg('start_offsets')::int[],
+	sqlc.arg('end_offsets')::int[]
+) AS new_mentions(user_id, start_offset, end_offset);
+
+-- name: DeleteMentionsByChirpId :many
+DELETE FROM mentions WHERE chirp_id = $1
+RETURNING user_id;
+
+-- name: GetMentionsByChirpIds :many
+SELECT mentions.chirp_id, mentions.user_id, mentions.start_offset, mentions.end_offset, users.handle
+FROM mentions
+JOIN users ON users.id = mentions.user_id
+WHERE mentions.chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[])
+ORDER BY mentions.chirp_id, mentions.start_offset;
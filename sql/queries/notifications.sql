@@ -0,0 +1,28 @@
+-- name: CreateNotifications :exec
+INSERT INTO notifications(id, created_at, user_id, actor_id, kind, chirp_id)
+SELECT gen_random_uuid(), NOW(), recipients.user_id, sqlc.narg('actor_id')::uuid, sqlc.arg('kind'), sqlc.narg('chirp_id')::uuid
+FROM unnest(sqlc.arg('user_ids')::uuid[]) AS recipients(user_id)
+WHERE sqlc.narg('actor_id')::uuid IS NULL OR recipients.user_id <> sqlc.narg('actor_id')::uuid
+ON CONFLICT DO NOTHING;
+
+-- name: GetNotificationsPage :many
+SELECT * FROM notifications
+WHERE user_id = sqlc.arg('user_id')
+AND (NOT sqlc.arg('unread_only')::boolean OR read_at IS NULL)
+AND (
+	sqlc.narg('cursor_created_at')::timestamp IS NULL
+	OR (created_at, id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: CountUnreadNotifications :one
+SELECT COUNT(*) FROM notifications
+WHERE user_id = $1 AND read_at IS NULL;
+
+-- name: MarkNotificationsRead :execrows
+UPDATE notifications
+SET read_at = NOW()
+WHERE user_id = sqlc.arg('user_id')
+AND read_at IS NULL
+AND (sqlc.arg('all')::boolean OR id = ANY(sqlc.arg('ids')::uuid[]));
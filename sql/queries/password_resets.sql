@@ -0,0 +1,22 @@
+-- name: CreatePasswordResetToken :exec
+INSERT INTO password_reset_tokens(token_hash, created_at, expires_at, user_id)
+VALUES (
+	$1,
+	NOW(),
+	$2,
+	$3
+);
+
+-- name: GetLatestPasswordResetToken :one
+SELECT * FROM password_reset_tokens
+WHERE user_id = $1
+ORDER BY created_at DESC
+LIMIT 1;
+
+-- name: DeletePasswordResetTokensByUserId :exec
+DELETE FROM password_reset_tokens WHERE user_id = $1;
+
+-- name: ConsumePasswordResetToken :one
+DELETE FROM password_reset_tokens
+WHERE token_hash = sqlc.arg('token_hash') AND expires_at > sqlc.arg('now')
+RETURNING *;
@@ -1,28 +1,45 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens(token, created_at, updated_at, user_id, expires_at, revoked_at)
+INSERT INTO refresh_tokens(token, created_at, updated_at, user_id, expires_at, revoked_at, session_id)
 VALUES (
 	$1,
 	NOW(),
 	NOW(),
 	$2,
-	NOW() + INTERVAL '60days',
-	NULL
+	$4,
+	NULL,
+	$3
 )
 RETURNING *;
 
 -- name: GetRefreshTokenByToken :one
 SELECT * FROM refresh_tokens WHERE token = $1;
 
--- name: GetRefreshTokenByUserId :one
-SELECT * FROM refresh_tokens WHERE user_id = $1;
-
--- name: RefreshTokenByToken :exec
+-- name: RotateRefreshToken :one
 UPDATE refresh_tokens
-SET expires_at = NOW() + INTERVAL '60 days', updated_at = NOW()
-WHERE token = $1;
+SET rotated_at = NOW(), updated_at = NOW()
+WHERE token = sqlc.arg('token')
+AND rotated_at IS NULL
+AND revoked_at IS NULL
+AND expires_at > sqlc.arg('now')
+RETURNING *;
 
 -- name: RevokeRefreshTokenByToken :exec
+WITH revoked_session AS (
+	UPDATE sessions
+	SET revoked_at = NOW()
+	WHERE sessions.id = (SELECT refresh_tokens.session_id FROM refresh_tokens WHERE refresh_tokens.token = $1)
+	AND sessions.revoked_at IS NULL
+)
 UPDATE refresh_tokens
 SET revoked_at = NOW(), updated_at = NOW()
 WHERE token = $1;
 
+-- name: RevokeRefreshTokensByUserId :exec
+WITH revoked_sessions AS (
+	UPDATE sessions
+	SET revoked_at = NOW()
+	WHERE sessions.user_id = $1 AND sessions.revoked_at IS NULL
+)
+UPDATE refresh_tokens
+SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
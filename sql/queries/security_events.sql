@@ -0,0 +1,11 @@
+-- name: CreateSecurityEvent :exec
+INSERT INTO security_events(id, created_at, user_id, kind, session_id, ip_address, user_agent)
+VALUES (
+	gen_random_uuid(),
+	NOW(),
+	$1,
+	$2,
+	$3,
+	$4,
+	$5
+);
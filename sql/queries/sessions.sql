@@ -0,0 +1,43 @@
+-- name: CreateSession :one
+INSERT INTO sessions(id, created_at, user_id, device_name, user_agent, ip_address, last_used_at)
+VALUES (
+	gen_random_uuid(),
+	NOW(),
+	$1,
+	$2,
+	$3,
+	$4,
+	NOW()
+)
+RETURNING *;
+
+-- name: GetActiveSessionsByUserId :many
+SELECT * FROM sessions
+WHERE user_id = sqlc.arg('user_id')
+AND revoked_at IS NULL
+AND EXISTS (
+	SELECT 1 FROM refresh_tokens
+	WHERE refresh_tokens.session_id = sessions.id
+	AND refresh_tokens.revoked_at IS NULL
+	AND refresh_tokens.expires_at > sqlc.arg('now')
+)
+ORDER BY last_used_at DESC, id DESC;
+
+-- name: TouchSession :exec
+UPDATE sessions
+SET last_used_at = NOW(), user_agent = $2, ip_address = $3
+WHERE id = $1;
+
+-- name: RevokeSession :one
+WITH revoked_session AS (
+	UPDATE sessions
+	SET revoked_at = NOW()
+	WHERE sessions.id = $1 AND sessions.user_id = $2 AND sessions.revoked_at IS NULL
+	RETURNING sessions.id
+), revoked_tokens AS (
+	UPDATE refresh_tokens
+	SET revoked_at = NOW(), updated_at = NOW()
+	WHERE refresh_tokens.session_id IN (SELECT id FROM revoked_session)
+	AND refresh_tokens.revoked_at IS NULL
+)
+SELECT COUNT(*) AS revoked FROM revoked_session;
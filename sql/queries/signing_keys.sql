@@ -0,0 +1,25 @@
+-- name: GetSigningKeys :many
+SELECT * FROM signing_keys
+WHERE retires_at IS NULL OR retires_at > $1
+ORDER BY created_at ASC;
+
+-- name: CreateSigningKey :one
+INSERT INTO signing_keys(kid, created_at, algorithm, private_key)
+VALUES (
+	$1,
+	$2,
+	$3,
+	$4
+)
+RETURNING *;
+
+-- name: RetireSigningKeys :exec
+UPDATE signing_keys
+SET retires_at = $2
+WHERE kid <> $1 AND retires_at IS NULL;
+
+-- name: DeleteRetiredSigningKeys :exec
+DELETE FROM signing_keys WHERE retires_at <= $1;
+
+-- name: LockSigningKeys :exec
+SELECT pg_advisory_xact_lock(hashtext('signing_keys'));
@@ -0,0 +1,118 @@
+-- name: FanOutChirp :execrows
+WITH chirp AS (
+	UPDATE chirps
+	SET fanned_out_at = NOW()
+	WHERE id = $1 AND fanned_out_at IS NULL AND deleted_at IS NULL
+	RETURNING id, user_id, created_at
+)
+INSERT INTO timeline_entries(user_id, chirp_id, author_id, created_at)
+SELECT follows.follower_id, chirp.id, chirp.user_id, chirp.created_at
+FROM chirp
+JOIN follows ON follows.followee_id = chirp.user_id
+ON CONFLICT DO NOTHING;
+
+-- name: GetPendingFanOuts :many
+SELECT chirps.id FROM chirps
+JOIN users ON users.id = chirps.user_id
+WHERE chirps.fanned_out_at IS NULL
+AND chirps.deleted_at IS NULL
+AND chirps.created_at > NOW() - INTERVAL '1 day'
+AND chirps.created_at < NOW() - INTERVAL '1 minute'
+AND users.follower_count < sqlc.arg('fanout_threshold')
+ORDER BY chirps.created_at
+LIMIT sqlc.arg('batch_size');
+
+-- name: BackfillTimeline :execrows
+INSERT INTO timeline_entries(user_id, chirp_id, author_id, created_at)
+SELECT sqlc.arg('user_id')::uuid, chirps.id, chirps.user_id, chirps.created_at
+FROM chirps
+WHERE chirps.user_id = sqlc.arg('author_id')
+AND chirps.fanned_out_at IS NOT NULL
+AND chirps.deleted_at IS NULL
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('backfill_size')
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteTimelineEntriesByAuthor :execrows
+DELETE FROM timeline_entries
+WHERE user_id = $1 AND author_id = $2
+AND NOT EXISTS (SELECT 1 FROM follows WHERE follower_id = $1 AND followee_id = $2);
+
+-- name: DeleteTimelineEntriesByChirpId :execrows
+DELETE FROM timeline_entries WHERE chirp_id = $1;
+
+-- name: GetTimelinePageOlder :many
+SELECT chirps.* FROM chirps
+WHERE chirps.deleted_at IS NULL
+AND (
+	sqlc.narg('cursor_created_at')::timestamp IS NULL
+	OR (chirps.created_at, chirps.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+AND chirps.id IN (
+	(
+		SELECT timeline_entries.chirp_id FROM timeline_entries
+		JOIN follows ON follows.follower_id = timeline_entries.user_id AND follows.followee_id = timeline_entries.author_id
+		JOIN chirps AS entry_chirps ON entry_chirps.id = timeline_entries.chirp_id AND entry_chirps.deleted_at IS NULL
+		WHERE timeline_entries.user_id = sqlc.arg('user_id')
+		AND (
+			sqlc.narg('cursor_created_at')::timestamp IS NULL
+			OR (timeline_entries.created_at, timeline_entries.chirp_id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+		)
+		ORDER BY timeline_entries.created_at DESC, timeline_entries.chirp_id DESC
+		LIMIT sqlc.arg('page_size')
+	)
+	UNION
+	(
+		SELECT pulled.id FROM chirps AS pulled
+		JOIN follows ON follows.followee_id = pulled.user_id
+		WHERE follows.follower_id = sqlc.arg('user_id')
+		AND pulled.fanned_out_at IS NULL
+		AND pulled.deleted_at IS NULL
+		AND (
+			sqlc.narg('cursor_created_at')::timestamp IS NULL
+			OR (pulled.created_at, pulled.id) < (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+		)
+		ORDER BY pulled.created_at DESC, pulled.id DESC
+		LIMIT sqlc.arg('page_size')
+	)
+)
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: GetTimelinePageNewer :many
+SELECT chirps.* FROM chirps
+WHERE chirps.deleted_at IS NULL
+AND (
+	sqlc.narg('cursor_created_at')::timestamp IS NULL
+	OR (chirps.created_at, chirps.id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+AND chirps.id IN (
+	(
+		SELECT timeline_entries.chirp_id FROM timeline_entries
+		JOIN follows ON follows.follower_id = timeline_entries.user_id AND follows.followee_id = timeline_entries.author_id
+		JOIN chirps AS entry_chirps ON entry_chirps.id = timeline_entries.chirp_id AND entry_chirps.deleted_at IS NULL
+		WHERE timeline_entries.user_id = sqlc.arg('user_id')
+		AND (
+			sqlc.narg('cursor_created_at')::timestamp IS NULL
+			OR (timeline_entries.created_at, timeline_entries.chirp_id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+		)
+		ORDER BY timeline_entries.created_at ASC, timeline_entries.chirp_id ASC
+		LIMIT sqlc.arg('page_size')
+	)
+	UNION
+	(
+		SELECT pulled.id FROM chirps AS pulled
+		JOIN follows ON follows.followee_id = pulled.user_id
+		WHERE follows.follower_id = sqlc.arg('user_id')
+		AND pulled.fanned_out_at IS NULL
+		AND pulled.deleted_at IS NULL
+		AND (
+			sqlc.narg('cursor_created_at')::timestamp IS NULL
+			OR (pulled.created_at, pulled.id) > (sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+		)
+		ORDER BY pulled.created_at ASC, pulled.id ASC
+		LIMIT sqlc.arg('page_size')
+	)
+)
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT sqlc.arg('page_size');
@@ -0,0 +1,65 @@
+-- name: UpsertTOTPCredential :one
+INSERT INTO totp_credentials(user_id, created_at, secret)
+VALUES (
+	$1,
+	NOW(),
+	$2
+)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0
+WHERE totp_credentials.confirmed_at IS NULL
+RETURNING *;
+
+-- name: GetTOTPCredential :one
+SELECT * FROM totp_credentials WHERE user_id = $1;
+
+-- name: ConfirmTOTPCredential :execrows
+UPDATE totp_credentials
+SET confirmed_at = NOW(), last_used_step = $2
+WHERE user_id = $1 AND confirmed_at IS NULL;
+
+-- name: UseTOTPStep :execrows
+UPDATE totp_credentials
+SET last_used_step = $2
+WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_used_step < $2;
+
+-- name: DeleteTOTPCredential :exec
+DELETE FROM totp_credentials WHERE user_id = $1;
+
+-- name: CreateRecoveryCodes :exec
+INSERT INTO recovery_codes(code_hash, created_at, user_id)
+SELECT code_hash, NOW(), sqlc.arg('user_id')::uuid
+FROM unnest(sqlc.arg('code_hashes')::text[]) AS code_hashes(code_hash);
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+
+-- name: CountUnusedRecoveryCodes :one
+SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes WHERE user_id = $1;
+
+-- name: CreateMFAChallenge :exec
+INSERT INTO mfa_challenges(token_hash, created_at, expires_at, user_id, device_name)
+VALUES (
+	$1,
+	NOW(),
+	$2,
+	$3,
+	$4
+);
+
+-- name: AttemptMFAChallenge :one
+UPDATE mfa_challenges
+SET attempts = attempts + 1
+WHERE token_hash = sqlc.arg('token_hash') AND expires_at > sqlc.arg('now')
+RETURNING *;
+
+-- name: DeleteMFAChallenge :exec
+DELETE FROM mfa_challenges WHERE token_hash = $1;
+
+-- name: DeleteMFAChallengesByUserId :exec
+DELETE FROM mfa_challenges WHERE user_id = $1;
@@ -0,0 +1,40 @@
+-- name: CreateOAuthState :exec
+INSERT INTO oauth_states(state_hash, created_at, expires_at, provider, code_verifier, nonce, device_name)
+VALUES (
+	$1,
+	NOW(),
+	$2,
+	$3,
+	$4,
+	$5,
+	$6
+);
+
+-- name: ConsumeOAuthState :one
+DELETE FROM oauth_states
+WHERE state_hash = sqlc.arg('state_hash') AND provider = sqlc.arg('provider') AND expires_at > sqlc.arg('now')
+RETURNING *;
+
+-- name: DeleteExpiredOAuthStates :exec
+DELETE FROM oauth_states WHERE expires_at <= $1;
+
+-- name: GetUserByIdentity :one
+SELECT users.* FROM user_identities
+JOIN users ON users.id = user_identities.user_id
+WHERE user_identities.provider = $1 AND user_identities.subject = $2;
+
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities(provider, subject, created_at, user_id, email, last_login_at)
+VALUES (
+	$1,
+	$2,
+	NOW(),
+	$3,
+	$4,
+	NOW()
+);
+
+-- name: TouchUserIdentity :exec
+UPDATE user_identities
+SET email = $3, last_login_at = NOW()
+WHERE provider = $1 AND subject = $2;
@@ -1,11 +1,12 @@
 -- name: CreateUser :one
-INSERT INTO users(id, created_at, updated_at, email, hashed_password)
+INSERT INTO users(id, created_at, updated_at, email, hashed_password, handle)
 VALUES (
 	gen_random_uuid(),
 	NOW(),
 	NOW(),
 	$1,
-	$2
+	$2,
+	$3
 )
 RETURNING *;
 
@@ -13,16 +14,71 @@ RETURNING *;
 DELETE FROM users;
 
 -- name: GetUserByEmail :one
-SELECT * FROM users WHERE email = $1;
-
--- name: UpdateUserEmailAndPasswordById :one
-UPDATE users
-SET email = $1, hashed_password = $2
-WHERE id = $3
-RETURNING *;
+SELECT * FROM users WHERE lower(email) = lower($1);
 
 -- name: UpgradeUserById :one
 UPDATE users 
 SET is_chirpy_red = true
 WHERE ID = $1
 RETURNING *;
+
+-- name: GetUserById :one
+SELECT * FROM users WHERE id = $1;
+
+-- name: GetUserByHandle :one
+SELECT * FROM users WHERE handle = $1;
+
+-- name: UpdateUserById :one
+UPDATE users
+SET email = COALESCE(sqlc.narg('email'), email),
+	email_verified_at = CASE
+		WHEN sqlc.narg('email') IS NULL OR lower(email) = lower(sqlc.narg('email')) THEN email_verified_at
+	END,
+	hashed_password = COALESCE(sqlc.narg('hashed_password'), hashed_password),
+	handle = CASE WHEN sqlc.arg('set_handle')::boolean THEN sqlc.narg('handle') ELSE handle END,
+	display_name = COALESCE(sqlc.narg('display_name'), display_name),
+	bio = COALESCE(sqlc.narg('bio'), bio),
+	location = COALESCE(sqlc.narg('location'), location),
+	website = COALESCE(sqlc.narg('website'), website),
+	avatar_media_id = CASE WHEN sqlc.arg('set_avatar_media_id')::boolean THEN sqlc.narg('avatar_media_id') ELSE avatar_media_id END,
+	updated_at = NOW()
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- name: UpdateUserPasswordById :exec
+UPDATE users
+SET hashed_password = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: RehashUserPassword :execrows
+UPDATE users
+SET hashed_password = sqlc.arg('new_hash')
+WHERE id = sqlc.arg('id') AND hashed_password = sqlc.arg('old_hash');
+
+-- name: GetUserTokenState :one
+SELECT token_version, suspended_at, EXISTS (
+	SELECT 1 FROM sessions
+	WHERE sessions.id = sqlc.narg('session_id')::uuid
+	AND sessions.revoked_at IS NOT NULL
+)::boolean AS session_revoked
+FROM users WHERE id = sqlc.arg('id');
+
+-- name: BumpTokenVersion :one
+UPDATE users
+SET token_version = token_version + 1
+WHERE id = $1
+RETURNING token_version;
+
+-- name: SuspendUser :one
+UPDATE users
+SET suspended_at = COALESCE(suspended_at, NOW()),
+	token_version = token_version + 1,
+	updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: UnsuspendUser :one
+UPDATE users
+SET suspended_at = NULL, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
@@ -0,0 +1,21 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN edited BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE chirp_revisions(
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	chirp_id UUID NOT NULL,
+	body TEXT NOT NULL,
+
+	CONSTRAINT fk_chirp
+	FOREIGN KEY (chirp_id)
+	REFERENCES chirps(id)
+	ON DELETE CASCADE
+);
+
+CREATE INDEX chirp_revisions_chirp_id_created_at_idx ON chirp_revisions (chirp_id, created_at);
+
+-- +goose Down
+DROP TABLE chirp_revisions;
+ALTER TABLE chirps DROP COLUMN edited;
@@ -0,0 +1,48 @@
+-- +goose Up
+CREATE TABLE likes(
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	user_id UUID NOT NULL,
+	chirp_id UUID NOT NULL,
+
+	CONSTRAINT likes_user_id_chirp_id_key UNIQUE (user_id, chirp_id),
+
+	CONSTRAINT fk_user
+	FOREIGN KEY (user_id)
+	REFERENCES users(id)
+	ON DELETE CASCADE,
+
+	CONSTRAINT fk_chirp
+	FOREIGN KEY (chirp_id)
+	REFERENCES chirps(id)
+	ON DELETE CASCADE
+);
+
+CREATE INDEX likes_user_id_created_at_id_idx ON likes (user_id, created_at, id);
+CREATE INDEX likes_chirp_id_idx ON likes (chirp_id);
+
+ALTER TABLE chirps
+ADD COLUMN like_count INTEGER NOT NULL DEFAULT 0;
+
+-- +goose StatementBegin
+CREATE FUNCTION likes_update_like_count() RETURNS TRIGGER AS $$
+BEGIN
+	IF TG_OP = 'INSERT' THEN
+		UPDATE chirps SET like_count = like_count + 1 WHERE id = NEW.chirp_id;
+	ELSE
+		UPDATE chirps SET like_count = like_count - 1 WHERE id = OLD.chirp_id;
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER likes_like_count
+AFTER INSERT OR DELETE ON likes
+FOR EACH ROW EXECUTE FUNCTION likes_update_like_count();
+
+-- +goose Down
+DROP TRIGGER likes_like_count ON likes;
+DROP FUNCTION likes_update_like_count;
+ALTER TABLE chirps DROP COLUMN like_count;
+DROP TABLE likes;
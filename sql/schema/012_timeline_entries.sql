@@ -0,0 +1,40 @@
+-- +goose Up
+CREATE TABLE timeline_entries(
+	user_id UUID NOT NULL,
+	chirp_id UUID NOT NULL,
+	author_id UUID NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (user_id, chirp_id),
+
+	CONSTRAINT fk_user
+	FOREIGN KEY (user_id)
+	REFERENCES users(id)
+	ON DELETE CASCADE,
+
+	CONSTRAINT fk_chirp
+	FOREIGN KEY (chirp_id)
+	REFERENCES chirps(id)
+	ON DELETE CASCADE,
+
+	CONSTRAINT fk_author
+	FOREIGN KEY (author_id)
+	REFERENCES users(id)
+	ON DELETE CASCADE
+);
+
+CREATE INDEX timeline_entries_user_id_created_at_chirp_id_idx ON timeline_entries (user_id, created_at, chirp_id);
+CREATE INDEX timeline_entries_user_id_author_id_idx ON timeline_entries (user_id, author_id);
+CREATE INDEX timeline_entries_chirp_id_idx ON timeline_entries (chirp_id);
+
+-- Chirps are pulled into timelines at read time until they have been fanned
+-- out, which chirps from large accounts never are.
+ALTER TABLE chirps
+ADD COLUMN fanned_out_at TIMESTAMP;
+
+CREATE INDEX chirps_pulled_idx ON chirps (user_id, created_at, id) WHERE fanned_out_at IS NULL;
+
+-- +goose Down
+DROP INDEX chirps_pulled_idx;
+ALTER TABLE chirps DROP COLUMN fanned_out_at;
+DROP TABLE timeline_entries;
@@ -0,0 +1,59 @@
+-- +goose Up
+ALTER TABLE users
+ADD COLUMN handle TEXT UNIQUE;
+
+CREATE TABLE mentions(
+	chirp_id UUID NOT NULL,
+	user_id UUID NOT NULL,
+	start_offset INTEGER NOT NULL,
+	end_offset INTEGER NOT NULL,
+
+	PRIMARY KEY (chirp_id, start_offset),
+
+	CONSTRAINT fk_chirp
+	FOREIGN KEY (chirp_id)
+	REFERENCES chirps(id)
+	ON DELETE CASCADE,
+
+	CONSTRAINT fk_user
+	FOREIGN KEY (user_id)
+	REFERENCES users(id)
+	ON DELETE CASCADE
+);
+
+CREATE INDEX mentions_user_id_idx ON mentions (user_id);
+
+CREATE TABLE notifications(
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	user_id UUID NOT NULL,
+	actor_id UUID,
+	kind TEXT NOT NULL,
+	chirp_id UUID,
+	read_at TIMESTAMP,
+
+	CONSTRAINT fk_user
+	FOREIGN KEY (user_id)
+	REFERENCES users(id)
+	ON DELETE CASCADE,
+
+	CONSTRAINT fk_actor
+	FOREIGN KEY (actor_id)
+	REFERENCES users(id)
+	ON DELETE CASCADE,
+
+	CONSTRAINT fk_chirp
+	FOREIGN KEY (chirp_id)
+	REFERENCES chirps(id)
+	ON DELETE CASCADE
+);
+
+CREATE INDEX notifications_user_id_created_at_id_idx ON notifications (user_id, created_at, id);
+
+-- Liking a chirp again after unliking it must not notify its author twice.
+CREATE UNIQUE INDEX notifications_like_key ON notifications (user_id, actor_id, chirp_id) WHERE kind = 'like';
+
+-- +goose Down
+DROP TABLE notifications;
+DROP TABLE mentions;
+ALTER TABLE users DROP COLUMN handle;
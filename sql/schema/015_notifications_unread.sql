@@ -0,0 +1,5 @@
+-- +goose Up
+CREATE INDEX notifications_unread_idx ON notifications (user_id) WHERE read_at IS NULL;
+
+-- +goose Down
+DROP INDEX notifications_unread_idx;
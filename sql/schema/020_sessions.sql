@@ -0,0 +1,60 @@
+-- +goose Up
+CREATE TABLE sessions(
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	user_id UUID NOT NULL,
+	device_name TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	ip_address TEXT NOT NULL DEFAULT '',
+	last_used_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	revoked_at TIMESTAMP,
+
+	CONSTRAINT fk_user
+	FOREIGN KEY (user_id)
+	REFERENCES users(id)
+	ON DELETE CASCADE
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id, last_used_at);
+
+ALTER TABLE refresh_tokens
+ADD COLUMN session_id UUID,
+ADD CONSTRAINT fk_session
+FOREIGN KEY (session_id)
+REFERENCES sessions(id)
+ON DELETE CASCADE;
+
+-- Each user had at most one refresh token, so every existing token becomes
+-- a session of its own.
+INSERT INTO sessions(id, created_at, user_id, last_used_at, revoked_at)
+SELECT gen_random_uuid(), COALESCE(created_at, NOW()), user_id, COALESCE(updated_at, NOW()), revoked_at
+FROM refresh_tokens;
+
+UPDATE refresh_tokens
+SET session_id = sessions.id
+FROM sessions
+WHERE sessions.user_id = refresh_tokens.user_id;
+
+ALTER TABLE refresh_tokens
+ALTER COLUMN session_id SET NOT NULL,
+DROP CONSTRAINT refresh_tokens_user_id_key;
+
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+CREATE INDEX refresh_tokens_session_id_idx ON refresh_tokens (session_id);
+
+-- +goose Down
+DROP INDEX refresh_tokens_session_id_idx;
+DROP INDEX refresh_tokens_user_id_idx;
+
+DELETE FROM refresh_tokens
+WHERE token NOT IN (
+	SELECT DISTINCT ON (user_id) token
+	FROM refresh_tokens
+	ORDER BY user_id, updated_at DESC
+);
+
+ALTER TABLE refresh_tokens
+DROP COLUMN session_id,
+ADD CONSTRAINT refresh_tokens_user_id_key UNIQUE (user_id);
+
+DROP TABLE sessions;
@@ -0,0 +1,29 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+ADD COLUMN rotated_at TIMESTAMP;
+
+CREATE TABLE security_events(
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	user_id UUID NOT NULL,
+	kind TEXT NOT NULL,
+	session_id UUID,
+	ip_address TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+
+	CONSTRAINT fk_user
+	FOREIGN KEY (user_id)
+	REFERENCES users(id)
+	ON DELETE CASCADE,
+
+	CONSTRAINT fk_session
+	FOREIGN KEY (session_id)
+	REFERENCES sessions(id)
+	ON DELETE SET NULL
+);
+
+CREATE INDEX security_events_user_id_idx ON security_events (user_id, created_at);
+
+-- +goose Down
+DROP TABLE security_events;
+ALTER TABLE refresh_tokens DROP COLUMN rotated_at;
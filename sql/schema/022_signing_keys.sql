@@ -0,0 +1,11 @@
+-- +goose Up
+CREATE TABLE signing_keys(
+	kid TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	algorithm TEXT NOT NULL,
+	private_key TEXT NOT NULL,
+	retires_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE signing_keys;
@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE login_throttles(
+	scope TEXT NOT NULL,
+	key TEXT NOT NULL,
+	failures INT NOT NULL DEFAULT 0,
+	last_failure_at TIMESTAMP NOT NULL,
+	blocked_until TIMESTAMP,
+
+	PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX login_throttles_last_failure_at_idx ON login_throttles (last_failure_at);
+
+-- +goose Down
+DROP TABLE login_throttles;
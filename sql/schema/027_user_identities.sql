@@ -0,0 +1,54 @@
+-- +goose Up
+-- Emails are matched without regard to case from here on, so accounts whose
+-- addresses differ only in case cannot all keep them. The one verified first,
+-- or else the oldest, keeps its address. The others move to a reserved
+-- .invalid one; their sessions keep working, so the owners can set a new one.
+UPDATE users
+SET email = id::text || '@duplicate.invalid', email_verified_at = NULL, updated_at = NOW()
+WHERE id IN (
+	SELECT id FROM (
+		SELECT id, row_number() OVER (
+			PARTITION BY lower(email)
+			ORDER BY email_verified_at NULLS LAST, created_at, id
+		) AS rank
+		FROM users
+	) ranked
+	WHERE rank > 1
+);
+
+ALTER TABLE users DROP CONSTRAINT users_email_key;
+CREATE UNIQUE INDEX users_email_lower_key ON users (lower(email));
+
+CREATE TABLE user_identities(
+	provider TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	user_id UUID NOT NULL,
+	email TEXT NOT NULL DEFAULT '',
+	last_login_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+	PRIMARY KEY (provider, subject),
+
+	CONSTRAINT fk_user
+	FOREIGN KEY (user_id)
+	REFERENCES users(id)
+	ON DELETE CASCADE
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
+
+CREATE TABLE oauth_states(
+	state_hash TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	expires_at TIMESTAMP NOT NULL,
+	provider TEXT NOT NULL,
+	code_verifier TEXT NOT NULL,
+	nonce TEXT NOT NULL,
+	device_name TEXT NOT NULL DEFAULT ''
+);
+
+-- +goose Down
+DROP TABLE oauth_states;
+DROP TABLE user_identities;
+DROP INDEX users_email_lower_key;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);